@@ -1,3 +1,5 @@
+set -e
+
 unameOut="$(uname -s)"
 case "${unameOut}" in
     Linux*)     machine=linux;;
@@ -14,8 +16,11 @@ if [ -z "${MAJOR_VERSION}" ]; then
     MAJOR_VERSION=master
 fi
 
-# This simply doesn't work
-# ./cli/yaml-merge/bin/yaml-merge-${machine} $MAJOR_VERSION
+# Set FORCE=true to overwrite generated files that were edited by hand
+FORCE_FLAG=
+if [ "${FORCE}" = "true" ]; then
+    FORCE_FLAG=--force
+fi
 
 LATEST_RELEASE_PATH=releases/${MAJOR_VERSION}/latest
 
@@ -25,18 +30,25 @@ else
       echo -e "\n - Commit is not latest, importing now"
 fi
 
-
-./cli/bin/yq-mac-x86 eval-all "select(fi == 0) * select(filename == \"./${LATEST_RELEASE_PATH}/patches/.github/workflows/ci.yml\")" ./${LATEST_RELEASE_PATH}/keycloak/.github/workflows/ci.yml ./${LATEST_RELEASE_PATH}/patches/.github/workflows/ci.yml > ./${LATEST_RELEASE_PATH}/build/.github/workflows/ci.step1.yml
-
-./cli/bin/yq-mac-x86 'del(.on.schedule)' ./${LATEST_RELEASE_PATH}/build/.github/workflows/ci.step1.yml  > ./${LATEST_RELEASE_PATH}/build/.github/workflows/ci.yml
-
-cp ${LATEST_RELEASE_PATH}/build/.github/workflows/ci.yml .github/workflows/${MAJOR_VERSION}-ci.yml
+# Merges the patches and vendors the upstream actions below ${LATEST_RELEASE_PATH}/build;
+# yaml-merge.yaml sets the schedules. The channel is selected by its folder,
+# as a bare ${MAJOR_VERSION} picks the newest minor tree of the version instead.
+./cli/yaml-merge/bin/yaml-merge-${machine} ${FORCE_FLAG} ${LATEST_RELEASE_PATH}
 
 mkdir -p .github/actions/${MAJOR_VERSION}/
 
 cp -R ./${LATEST_RELEASE_PATH}/keycloak/.github/actions/*  .github/actions/${MAJOR_VERSION}/
+# The action metadata merged, pinned and checked by yaml-merge replaces the upstream copy;
+# the folder is missing or empty when no actions are vendored
+if [ -n "$(ls -A ./${LATEST_RELEASE_PATH}/build/.github/actions 2>/dev/null)" ]; then
+  cp -R ./${LATEST_RELEASE_PATH}/build/.github/actions/*  .github/actions/${MAJOR_VERSION}/
+fi
 
 # Adapted from https://stackoverflow.com/questions/1583219/how-can-i-do-a-recursive-find-replace-of-a-string-with-awk-or-sed
-find ./.github/actions/${MAJOR_VERSION} \( -type d -name .git -prune \) -o -type f -print0 | xargs -0 sed -i "" "s/.github\/actions/.github\/actions\/${MAJOR_VERSION}/g" 
+# -i with an attached suffix is understood by both BSD and GNU sed
+find ./.github/actions/${MAJOR_VERSION} \( -type d -name .git -prune \) -o -type f -print0 | xargs -0 sed -i.yaml-merge-bak "s/.github\/actions/.github\/actions\/${MAJOR_VERSION}/g"
+find ./.github/actions/${MAJOR_VERSION} -name '*.yaml-merge-bak' -delete
 
-sed -i "" "s/.github\/actions/.github\/actions\/${MAJOR_VERSION}/g" .github/workflows/${MAJOR_VERSION}-ci.yml
\ No newline at end of file
+# Publishes every merged workflow as .github/workflows/${MAJOR_VERSION}-*.yml and
+# keeps the conditions of the conditional action in sync
+./cli/yaml-merge/bin/yaml-merge-${machine} publish ${FORCE_FLAG} ${LATEST_RELEASE_PATH}
@@ -1,2 +1,4 @@
-env GOOS=darwin GOARCH=amd64 go build -o bin/yaml-merge-mac
-env GOOS=linux go build -o bin/yaml-merge-linux
\ No newline at end of file
+VERSION=$(git describe --tags --always --dirty 2>/dev/null || echo dev)
+LDFLAGS="-X yaml-merge/cmd.Version=${VERSION}"
+env GOOS=darwin GOARCH=amd64 go build -ldflags "${LDFLAGS}" -o bin/yaml-merge-mac
+env GOOS=linux go build -ldflags "${LDFLAGS}" -o bin/yaml-merge-linux
@@ -0,0 +1,269 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultActionPolicyFile is the policy file read when the action policy
+// names none.
+const DefaultActionPolicyFile = "action-policy.yaml"
+
+// Actions a deny rule takes on the steps it matches.
+const (
+	DenyFail    = "fail"
+	DenyRemove  = "remove"
+	DenyReplace = "replace"
+)
+
+// ActionPolicy controls which actions and reusable workflows of other
+// repositories the merged files may use. References are matched against
+// globs with and without their ref, so actions/checkout matches every
+// version of the action and runforesight/* every action of the owner.
+// Local actions and Docker images are not subject to the policy.
+type ActionPolicy struct {
+	// File is the policy file, holding allow and deny lists like this
+	// section, read from the workspace root; DefaultActionPolicyFile by
+	// default. Its rules come after the ones of the configuration.
+	File string `yaml:"file"`
+	// Allow lists the references that may be used. When it is empty every
+	// reference that is not denied may be used.
+	Allow []string `yaml:"allow"`
+	// Deny lists the references that may not be used, before Allow.
+	Deny []DeniedAction `yaml:"deny"`
+}
+
+// DeniedAction is a deny rule of the action policy.
+type DeniedAction struct {
+	// Uses is a glob of the denied references.
+	Uses string `yaml:"uses"`
+	// Action is what happens to a step using a denied reference: fail (the
+	// default) fails the merge, remove removes the step and replace puts
+	// Replace in its place. Jobs calling a denied workflow always fail.
+	Action string `yaml:"action"`
+	// Replace is the step replacing a denied one. It keeps the id and if of
+	// the denied step unless it sets its own.
+	Replace yaml.Node `yaml:"replace"`
+	// Reason explains the rule in the messages about it.
+	Reason string `yaml:"reason"`
+}
+
+// load appends the rules of the policy file to p. A missing file is only
+// an error when it was named in the configuration.
+func (p *ActionPolicy) load(fsys fs.ReadFileFS) error {
+	name := p.File
+	if name == "" {
+		name = DefaultActionPolicyFile
+	}
+	data, err := fsys.ReadFile(name)
+	if errors.Is(err, fs.ErrNotExist) && p.File == "" {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var file ActionPolicy
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return fmt.Errorf("parsing %s: %w", name, err)
+	}
+	p.Allow = append(p.Allow, file.Allow...)
+	p.Deny = append(p.Deny, file.Deny...)
+	return nil
+}
+
+// validate checks the globs and deny rules of the policy.
+func (p *ActionPolicy) validate() error {
+	for _, glob := range p.Allow {
+		if _, err := path.Match(glob, ""); err != nil {
+			return fmt.Errorf("allow %q: %w", glob, err)
+		}
+	}
+	for i, rule := range p.Deny {
+		if _, err := path.Match(rule.Uses, ""); err != nil || rule.Uses == "" {
+			return fmt.Errorf("deny[%d]: invalid uses %q", i, rule.Uses)
+		}
+		switch rule.Action {
+		case "", DenyFail, DenyRemove:
+		case DenyReplace:
+			if rule.Replace.Kind != yaml.MappingNode {
+				return fmt.Errorf("deny[%d]: replace must be a step", i)
+			}
+		default:
+			return fmt.Errorf("deny[%d]: action must be fail, remove or replace", i)
+		}
+	}
+	return nil
+}
+
+// matchesReference reports whether glob matches a reference with or without
+// its ref.
+func matchesReference(glob, uses string) bool {
+	action, _, _ := strings.Cut(uses, "@")
+	for _, s := range []string{uses, action} {
+		if ok, _ := path.Match(glob, s); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// denial returns the rule denying a reference, or nil when it may be used.
+// A reference missing from a non-empty allow list is denied by a fail rule.
+func (p *ActionPolicy) denial(uses string) *DeniedAction {
+	for i := range p.Deny {
+		if matchesReference(p.Deny[i].Uses, uses) {
+			return &p.Deny[i]
+		}
+	}
+	if len(p.Allow) == 0 {
+		return nil
+	}
+	for _, glob := range p.Allow {
+		if matchesReference(glob, uses) {
+			return nil
+		}
+	}
+	return &DeniedAction{Action: DenyFail, Reason: "not in the allow list"}
+}
+
+// describe returns the reference and the reason it is denied.
+func (r *DeniedAction) describe(uses string) string {
+	if r.Reason == "" {
+		return uses + " is denied"
+	}
+	return fmt.Sprintf("%s is denied (%s)", uses, r.Reason)
+}
+
+// apply enforces the policy on the steps and reusable workflow jobs of a
+// workflow or composite action.
+func (p *ActionPolicy) apply(ctx transformContext, doc *yaml.Node) error {
+	root := documentRoot(doc)
+	if root == nil || !(ctx.isWorkflow() || ctx.isAction()) {
+		return nil
+	}
+	var denied []string
+	forEachJob(root, func(id string, job *yaml.Node) {
+		uses := mappingValue(job, "uses")
+		if uses == nil || !isRemoteAction(uses.Value) {
+			return
+		}
+		if rule := p.denial(uses.Value); rule != nil {
+			denied = append(denied, fmt.Sprintf("job %s: %s", id, rule.describe(uses.Value)))
+		}
+	})
+	forEachStepList(root, func(job string, steps *yaml.Node) {
+		kept := steps.Content[:0]
+		for _, step := range steps.Content {
+			uses := mappingValue(step, "uses")
+			if uses == nil || !isRemoteAction(uses.Value) {
+				kept = append(kept, step)
+				continue
+			}
+			rule := p.denial(uses.Value)
+			switch {
+			case rule == nil:
+				kept = append(kept, step)
+			case rule.Action == DenyRemove:
+				ctx.report("removing step %s: %s", stepDescription(job, step), rule.describe(uses.Value))
+			case rule.Action == DenyReplace:
+				ctx.report("replacing step %s: %s", stepDescription(job, step), rule.describe(uses.Value))
+				kept = append(kept, replacementStep(step, &rule.Replace))
+			default:
+				denied = append(denied, fmt.Sprintf("step %s: %s", stepDescription(job, step), rule.describe(uses.Value)))
+				kept = append(kept, step)
+			}
+		}
+		steps.Content = kept
+	})
+	if len(denied) > 0 {
+		return fmt.Errorf("denied actions: %s", strings.Join(denied, "; "))
+	}
+	return nil
+}
+
+// stepDescription names a step of a job, or of a composite action when job
+// is "", in messages.
+func stepDescription(job string, step *yaml.Node) string {
+	name := mappingValue(step, "name")
+	if name == nil {
+		name = mappingValue(step, "uses")
+	}
+	if job == "" {
+		return fmt.Sprintf("%q", name.Value)
+	}
+	return fmt.Sprintf("%q of job %s", name.Value, job)
+}
+
+// replacementStep returns a copy of replace with the id and if of the step
+// it replaces, unless it sets its own.
+func replacementStep(step, replace *yaml.Node) *yaml.Node {
+	replacement := cloneNode(replace)
+	rewriteStyle(replacement, 0)
+	for _, key := range []string{"if", "id"} {
+		if value := mappingValue(step, key); value != nil && mappingValue(replacement, key) == nil {
+			replacement.Content = append([]*yaml.Node{stringNode(key), value}, replacement.Content...)
+		}
+	}
+	return replacement
+}
+
+// referencedActions returns the references to actions and workflows of
+// other repositories in a merged document, sorted and without
+// duplicates. Pinned references are listed by the ref in their comment.
+func referencedActions(doc *yaml.Node) []string {
+	root := documentRoot(doc)
+	if root == nil {
+		return nil
+	}
+	seen := map[string]bool{}
+	add := func(node *yaml.Node) {
+		uses := mappingValue(node, "uses")
+		if uses == nil || uses.Kind != yaml.ScalarNode || !isRemoteAction(uses.Value) {
+			return
+		}
+		reference := uses.Value
+		if action, ref, ok := strings.Cut(reference, "@"); ok && commitPattern.MatchString(ref) && uses.LineComment != "" {
+			reference = action + "@" + strings.TrimSpace(strings.TrimPrefix(uses.LineComment, "#"))
+		}
+		seen[reference] = true
+	}
+	forEachJob(root, func(_ string, job *yaml.Node) {
+		add(job)
+	})
+	forEachStep(root, add)
+	references := make([]string, 0, len(seen))
+	for reference := range seen {
+		references = append(references, reference)
+	}
+	sort.Strings(references)
+	return references
+}
+
+// reportActions prints every reference the outputs of a channel use,
+// with the outputs using it relative to the channel's output folder.
+func reportActions(out io.Writer, channel Channel, outputs []ManifestOutput) {
+	users := map[string][]string{}
+	for _, output := range outputs {
+		name := strings.TrimPrefix(output.Path, channel.Output+"/")
+		for _, reference := range output.Actions {
+			users[reference] = append(users[reference], name)
+		}
+	}
+	references := make([]string, 0, len(users))
+	for reference := range users {
+		references = append(references, reference)
+	}
+	sort.Strings(references)
+	fmt.Fprintf(out, "Actions used by %s: %d \n", channel.Name, len(references))
+	for _, reference := range references {
+		sort.Strings(users[reference])
+		fmt.Fprintf(out, "  %s: %s \n", reference, strings.Join(users[reference], ", "))
+	}
+}
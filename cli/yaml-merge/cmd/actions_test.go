@@ -0,0 +1,131 @@
+package cmd
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v3"
+)
+
+const foresightPolicy = `deny:
+    - uses: runforesight/foresight-test-kit-action
+      action: remove
+      reason: needs FORESIGHT_API_KEY
+    - uses: webfactory/ssh-agent@*
+      action: replace
+      replace:
+          name: Start ssh-agent
+          run: eval "$(ssh-agent -s)"
+    - uses: evil/*
+`
+
+func TestActionPolicy(t *testing.T) {
+	fsys := newMemFS()
+	assert.NoError(t, fsys.WriteFile(DefaultActionPolicyFile, []byte(foresightPolicy), 0644))
+	policy := &ActionPolicy{}
+	assert.NoError(t, policy.load(fsys))
+	assert.NoError(t, policy.validate())
+
+	var doc yaml.Node
+	assert.NoError(t, yaml.Unmarshal([]byte(`jobs:
+    build:
+        steps:
+            - uses: actions/checkout@v3
+            - id: ssh
+              if: github.event_name == 'push'
+              uses: webfactory/ssh-agent@v0.7.0
+            - name: Upload test results
+              uses: runforesight/foresight-test-kit-action@v1.2.1
+              with:
+                  api_key: ${{ secrets.FORESIGHT_API_KEY }}
+            - uses: ./.github/actions/conditional
+`), &doc))
+
+	var out bytes.Buffer
+	ctx := transformContext{channel: Channel{Name: "master"}, path: "master/build/.github/workflows/ci.yml", out: &out}
+	assert.NoError(t, policy.apply(ctx, &doc))
+
+	data, err := yaml.Marshal(&doc)
+	assert.NoError(t, err)
+	assert.Equal(t, `jobs:
+    build:
+        steps:
+            - uses: actions/checkout@v3
+            - id: ssh
+              if: github.event_name == 'push'
+              name: Start ssh-agent
+              run: eval "$(ssh-agent -s)"
+            - uses: ./.github/actions/conditional
+`, string(data))
+	assert.Equal(t, ""+
+		"master/build/.github/workflows/ci.yml: replacing step \"webfactory/ssh-agent@v0.7.0\" of job build: webfactory/ssh-agent@v0.7.0 is denied \n"+
+		"master/build/.github/workflows/ci.yml: removing step \"Upload test results\" of job build: runforesight/foresight-test-kit-action@v1.2.1 is denied (needs FORESIGHT_API_KEY) \n",
+		out.String())
+}
+
+func TestActionPolicyFails(t *testing.T) {
+	var doc yaml.Node
+	assert.NoError(t, yaml.Unmarshal([]byte(`jobs:
+    build:
+        steps:
+            - uses: actions/checkout@v3
+            - uses: actions/setup-java@v3
+    shared:
+        uses: evil/workflows/.github/workflows/x.yml@main
+`), &doc))
+	policy := &ActionPolicy{Allow: []string{"actions/checkout"}, Deny: []DeniedAction{{Uses: "evil/*/*/*/*"}}}
+	assert.NoError(t, policy.validate())
+	ctx := transformContext{channel: Channel{Name: "master"}, path: "master/build/.github/workflows/ci.yml", out: &bytes.Buffer{}}
+	assert.EqualError(t, policy.apply(ctx, &doc), "denied actions: "+
+		"job shared: evil/workflows/.github/workflows/x.yml@main is denied; "+
+		"step \"actions/setup-java@v3\" of job build: actions/setup-java@v3 is denied (not in the allow list)")
+}
+
+func TestActionPolicyValidation(t *testing.T) {
+	assert.EqualError(t, (&ActionPolicy{Deny: []DeniedAction{{}}}).validate(), `deny[0]: invalid uses ""`)
+	assert.EqualError(t, (&ActionPolicy{Deny: []DeniedAction{{Uses: "a/b", Action: "replace"}}}).validate(), "deny[0]: replace must be a step")
+	assert.EqualError(t, (&ActionPolicy{Deny: []DeniedAction{{Uses: "a/b", Action: "skip"}}}).validate(), "deny[0]: action must be fail, remove or replace")
+	assert.Error(t, (&ActionPolicy{File: "missing.yaml"}).load(newMemFS()))
+}
+
+func TestReportActionsPerVersion(t *testing.T) {
+	fsys := newMemFS()
+	assert.NoError(t, fsys.WriteFile(DefaultConfigFile, []byte(`transforms:
+    actions: {}
+    pin:
+        pins:
+            actions/checkout@v3: `+checkoutSHA+`
+`), 0644))
+	assert.NoError(t, fsys.WriteFile(DefaultActionPolicyFile, []byte(foresightPolicy), 0644))
+	assert.NoError(t, fsys.WriteFile("master/keycloak/.github/workflows/ci.yml", []byte(`jobs:
+    build:
+        steps:
+            - uses: actions/checkout@v3
+            - uses: runforesight/foresight-test-kit-action@v1.2.1
+`), 0644))
+	assert.NoError(t, fsys.WriteFile("master/patches/.github/workflows/ci.yml", []byte("name: CI\n"), 0644))
+	assert.NoError(t, fsys.WriteFile("master/keycloak/.github/actions/build/action.yml", []byte(`runs:
+    using: composite
+    steps:
+        - uses: actions/setup-java@v3
+        - uses: actions/checkout@v3
+`), 0644))
+	assert.NoError(t, fsys.WriteFile("master/patches/.github/actions/build/action.yml", []byte("name: Build\n"), 0644))
+
+	cfg, err := loadConfig(fsys, DefaultConfigFile, false)
+	assert.NoError(t, err)
+	var out bytes.Buffer
+	assert.NoError(t, newMerger(fsys, cfg, &out).mergeVersions("master"))
+	assert.Contains(t, out.String(), "Actions used by master: 2 \n"+
+		"  actions/checkout@v3: .github/actions/build/action.yml, .github/workflows/ci.yml \n"+
+		"  actions/setup-java@v3: .github/actions/build/action.yml \n")
+	assert.Contains(t, out.String(), "action not pinned: actions/setup-java@v3 (no pin)")
+
+	// up to date outputs are reported from the manifest
+	out.Reset()
+	assert.NoError(t, newMerger(fsys, cfg, &out).mergeVersions("master"))
+	assert.Equal(t, 2, strings.Count(out.String(), "upToDate"))
+	assert.Contains(t, out.String(), "  actions/checkout@v3: .github/actions/build/action.yml, .github/workflows/ci.yml \n")
+}
@@ -0,0 +1,76 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultConfigFile is the configuration file looked up at the workspace root
+// when --config is not given.
+const DefaultConfigFile = "yaml-merge.yaml"
+
+// Config is the yaml-merge configuration. Every section is optional; a
+// workspace without a configuration file behaves like the built-in defaults.
+type Config struct {
+	Layout     Layout            `yaml:"layout"`
+	Transforms Transforms        `yaml:"transforms"`
+	Dispatcher *DispatcherConfig `yaml:"dispatcher"`
+}
+
+// defaultConfig returns the configuration used when no file is present.
+func defaultConfig() *Config {
+	return &Config{}
+}
+
+// loadConfig reads the configuration file name from fsys. A missing file is
+// only an error when the caller asked for it explicitly; otherwise the
+// defaults are returned.
+func loadConfig(fsys fs.ReadFileFS, name string, explicit bool) (*Config, error) {
+	data, err := fsys.ReadFile(name)
+	if errors.Is(err, fs.ErrNotExist) && !explicit {
+		return defaultConfig(), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	cfg := defaultConfig()
+	if err := yaml.Unmarshal(data, cfg); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", name, err)
+	}
+	if err := cfg.Layout.validate(); err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	if cfg.Transforms.Actions != nil {
+		if err := cfg.Transforms.Actions.load(fsys); err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+	}
+	if cfg.Transforms.Permissions != nil {
+		if err := cfg.Transforms.Permissions.load(fsys); err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+	}
+	if cfg.Transforms.Pin != nil {
+		if err := cfg.Transforms.Pin.load(fsys); err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+	}
+	if err := cfg.Transforms.validate(); err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	return cfg, nil
+}
+
+// hash returns a digest of the effective configuration. It is taken over
+// the parsed configuration, so reformatting or commenting the file does
+// not invalidate generated outputs.
+func (c *Config) hash() (string, error) {
+	data, err := yaml.Marshal(c)
+	if err != nil {
+		return "", err
+	}
+	return hashBytes(data), nil
+}
@@ -0,0 +1,305 @@
+package cmd
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Defaults of the dispatcher configuration.
+const (
+	DefaultDispatcherFile     = ".github/workflows/dispatch.yml"
+	DefaultDispatcherName     = "CI"
+	DefaultDispatcherWorkflow = "ci.yml"
+)
+
+// DispatcherConfig configures the dispatcher workflow that publish
+// generates: one workflow calling the reusable workflow of every version
+// published to the workspace root, each only when the version's files
+// changed.
+type DispatcherConfig struct {
+	// File is the generated workflow, DefaultDispatcherFile by default.
+	File string `yaml:"file"`
+	// Name is the workflow name, DefaultDispatcherName by default.
+	Name string `yaml:"name"`
+	// Workflow is the file name of the reusable workflow of each version,
+	// DefaultDispatcherWorkflow by default. Versions that did not publish it
+	// are skipped.
+	Workflow string `yaml:"workflow"`
+	// On holds the triggers of the dispatcher; push, pull_request and
+	// workflow_dispatch when empty. The called workflows are published
+	// without these triggers, apart from workflow_dispatch, so they do not
+	// run a second time for the same event.
+	On yaml.Node `yaml:"on"`
+	// Inputs are passed to every version's workflow. The placeholders
+	// {version} and {root} are replaced by the version and its root.
+	Inputs map[string]string `yaml:"inputs"`
+	// Secrets are passed to every version's workflow.
+	Secrets map[string]string `yaml:"secrets"`
+}
+
+// file returns the path of the generated workflow.
+func (d *DispatcherConfig) file() string {
+	if d.File != "" {
+		return d.File
+	}
+	return DefaultDispatcherFile
+}
+
+// workflowName returns the file name of the reusable workflow of each
+// version.
+func (d *DispatcherConfig) workflowName() string {
+	if d.Workflow != "" {
+		return d.Workflow
+	}
+	return DefaultDispatcherWorkflow
+}
+
+// on returns the triggers of the dispatcher.
+func (d *DispatcherConfig) on() *yaml.Node {
+	if d.On.Kind != 0 {
+		return &d.On
+	}
+	on := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+	setMappingValue(on, "push", nullNode())
+	setMappingValue(on, "pull_request", nullNode())
+	setMappingValue(on, "workflow_dispatch", nullNode())
+	return on
+}
+
+// dispatchedEvents returns the events the dispatcher calls a merged
+// workflow for, which the published workflow must not trigger on itself:
+// those of the dispatcher but workflow_dispatch, as starting one version
+// by hand does not start the dispatcher. It is nil when publish generates
+// no dispatcher or the dispatcher does not call workflows of that name.
+func (c *Config) dispatchedEvents(workflow string) []string {
+	d := c.Dispatcher
+	if d == nil || path.Base(workflow) != d.workflowName() {
+		return nil
+	}
+	var events []string
+	for _, event := range eventNames(d.on()) {
+		if event != "workflow_dispatch" && event != "workflow_call" {
+			events = append(events, event)
+		}
+	}
+	return events
+}
+
+// removeDispatchedEvents removes events from the triggers of a reusable
+// workflow root and returns those it had.
+func removeDispatchedEvents(root *yaml.Node, events []string) []string {
+	if len(events) == 0 || !isReusableWorkflow(root) {
+		return nil
+	}
+	present := eventNames(mappingValue(root, "on"))
+	var removed []string
+	for _, event := range events {
+		if containsString(present, event) {
+			removed = append(removed, event)
+		}
+	}
+	if len(removed) == 0 {
+		return nil
+	}
+	on := eventMapping(root)
+	for _, event := range removed {
+		removeMappingKey(on, event)
+	}
+	return removed
+}
+
+// jobIDPattern matches the characters a job id may not contain.
+var jobIDPattern = regexp.MustCompile(`[^A-Za-z0-9_-]`)
+
+// dispatcherJobID returns the id of the job calling channel's workflow.
+func dispatcherJobID(channel Channel) string {
+	return jobIDPattern.ReplaceAllString(channelSelector(channel), "_")
+}
+
+// writeDispatcher stages the dispatcher workflow in txn. It calls the
+// workflow of every version published to the workspace root, including
+// those staged in txn, so publishing or pruning a version updates it.
+// Published workflows without a workflow_call trigger cannot be called and
+// are left out. The dispatcher goes through the actions policy, permissions
+// and pin transforms like merged workflows.
+func (m *merger) writeDispatcher(txn *writeTxn) error {
+	d := m.cfg.Dispatcher
+	channels, err := m.cfg.Layout.Discover(m.fsys)
+	if err != nil {
+		return err
+	}
+	sortChannels(channels)
+
+	workflow := d.workflowName()
+	var versions []Channel
+	for _, channel := range channels {
+		published := publishedName(channel, workflow)
+		data, err := txn.ReadFile(published)
+		if err != nil {
+			continue
+		}
+		if !isReusable(data) {
+			fmt.Fprintf(m.out, "%s has no workflow_call trigger, the dispatcher does not call it \n", published)
+			continue
+		}
+		versions = append(versions, channel)
+	}
+
+	target := d.file()
+	doc := d.workflow(versions, workflow)
+	ctx := transformContext{path: target, out: m.out}
+	for _, t := range m.cfg.dispatcherTransforms() {
+		if err := t(ctx, doc); err != nil {
+			return fmt.Errorf("%s: %w", target, err)
+		}
+	}
+	body, err := yaml.Marshal(doc)
+	if err != nil {
+		return err
+	}
+	names := make([]string, len(versions))
+	for i, channel := range versions {
+		names[i] = channelSelector(channel)
+	}
+	data := withGeneratedHeader(body, "dispatcher of "+strings.Join(names, ", "))
+
+	if err := m.checkPublishTarget(target, &Manifest{}, "the dispatcher configuration"); err != nil {
+		return err
+	}
+	fmt.Fprintf(m.out, "Dispatching %s to %s \n", target, strings.Join(names, ", "))
+	return txn.WriteFile(target, data, 0644)
+}
+
+// dispatcherTransforms returns the enabled transforms that apply to the
+// generated dispatcher: those vetting the actions it uses and the token
+// they get. The jobs calling the versions get the permissions of the
+// workflow unless the permissions jobs set them. The other transforms
+// adapt upstream workflows to a version and have nothing to do there.
+func (c *Config) dispatcherTransforms() []transform {
+	var transforms []transform
+	if c.Transforms.Actions != nil {
+		transforms = append(transforms, c.Transforms.Actions.apply)
+	}
+	if c.Transforms.Permissions != nil {
+		transforms = append(transforms, c.Transforms.Permissions.apply)
+	}
+	if c.Transforms.Pin != nil {
+		transforms = append(transforms, c.Transforms.Pin.apply)
+	}
+	return transforms
+}
+
+// isReusable reports whether a generated workflow can be called from
+// another workflow.
+func isReusable(data []byte) bool {
+	if header, ok := parseGeneratedHeader(data); ok {
+		data = header.body
+	}
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return false
+	}
+	return isReusableWorkflow(documentRoot(&doc))
+}
+
+// isReusableWorkflow reports whether the workflow root has a workflow_call
+// trigger.
+func isReusableWorkflow(root *yaml.Node) bool {
+	on := mappingValue(root, "on")
+	switch {
+	case on == nil:
+		return false
+	case on.Kind == yaml.ScalarNode:
+		return on.Value == "workflow_call"
+	case on.Kind == yaml.SequenceNode:
+		for _, event := range on.Content {
+			if event.Value == "workflow_call" {
+				return true
+			}
+		}
+		return false
+	}
+	return mappingValue(on, "workflow_call") != nil
+}
+
+// workflow builds the dispatcher workflow for versions. A changes job
+// filters the changed paths of each version; the job of a version runs
+// when its paths changed or the dispatcher was started by hand.
+func (d *DispatcherConfig) workflow(versions []Channel, workflow string) *yaml.Node {
+	root := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+	name := d.Name
+	if name == "" {
+		name = DefaultDispatcherName
+	}
+	setMappingValue(root, "name", stringNode(name))
+
+	setMappingValue(root, "on", d.on())
+
+	jobs := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+	setMappingValue(root, "jobs", jobs)
+
+	changes := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+	outputs := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+	var filters strings.Builder
+	for _, channel := range versions {
+		id := dispatcherJobID(channel)
+		setMappingValue(outputs, id, stringNode("${{ steps.filter.outputs."+id+" }}"))
+		filters.WriteString(id + ":\n")
+		for _, p := range versionPaths(channel) {
+			filters.WriteString("  - '" + p + "'\n")
+		}
+	}
+	setMappingValue(changes, "runs-on", stringNode("ubuntu-latest"))
+	setMappingValue(changes, "outputs", outputs)
+	filter := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+	setMappingValue(filter, "id", stringNode("filter"))
+	setMappingValue(filter, "uses", stringNode("dorny/paths-filter@v2"))
+	with := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+	setMappingValue(with, "filters", &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Style: yaml.LiteralStyle, Value: filters.String()})
+	setMappingValue(filter, "with", with)
+	checkout := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+	setMappingValue(checkout, "uses", stringNode("actions/checkout@v3"))
+	setMappingValue(changes, "steps", &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq", Content: []*yaml.Node{checkout, filter}})
+	setMappingValue(jobs, "changes", changes)
+
+	for _, channel := range versions {
+		id := dispatcherJobID(channel)
+		job := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+		setMappingValue(job, "needs", stringNode("changes"))
+		setMappingValue(job, "if", stringNode("github.event_name == 'workflow_dispatch' || needs.changes.outputs."+id+" == 'true'"))
+		setMappingValue(job, "uses", stringNode("./"+publishedName(channel, workflow)))
+		replacer := strings.NewReplacer("{version}", channelSelector(channel), "{root}", channel.Root)
+		if len(d.Inputs) > 0 {
+			setMappingValue(job, "with", sortedStrings(d.Inputs, replacer))
+		}
+		if len(d.Secrets) > 0 {
+			setMappingValue(job, "secrets", sortedStrings(d.Secrets, nil))
+		}
+		setMappingValue(jobs, id, job)
+	}
+	return &yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{root}}
+}
+
+// sortedStrings returns values as a mapping in key order, with replacer
+// applied to every value if it is not nil.
+func sortedStrings(values map[string]string, replacer *strings.Replacer) *yaml.Node {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	node := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+	for _, key := range keys {
+		value := values[key]
+		if replacer != nil {
+			value = replacer.Replace(value)
+		}
+		setMappingValue(node, key, stringNode(value))
+	}
+	return node
+}
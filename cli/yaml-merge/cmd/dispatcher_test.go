@@ -0,0 +1,176 @@
+package cmd
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDispatcherWorkflow(t *testing.T) {
+	fsys := newMemFS()
+	reusable := []byte("name: CI\non:\n    workflow_call: {}\n")
+	assert.NoError(t, fsys.WriteFile("master/build/.github/workflows/ci.yml", reusable, 0644))
+	assert.NoError(t, fsys.WriteFile("releases/v21/latest/build/.github/workflows/ci.yml", []byte("name: CI\non: push\n"), 0644))
+	assert.NoError(t, fsys.WriteFile("releases/v20/0/latest/build/.github/workflows/ci.yml", reusable, 0644))
+	assert.NoError(t, fsys.WriteFile("releases/v20/0/latest/build/.github/workflows/docs.yml", reusable, 0644))
+	// a version without the reusable workflow gets no job
+	assert.NoError(t, fsys.WriteFile("releases/v19/latest/build/.github/workflows/docs.yml", reusable, 0644))
+	// a version with a build that is not published gets no job
+	assert.NoError(t, fsys.WriteFile("releases/v18/latest/build/.github/workflows/ci.yml", reusable, 0644))
+
+	cfg := defaultConfig()
+	cfg.Dispatcher = &DispatcherConfig{
+		Inputs:  map[string]string{"config-path": "{root}/yaml-merge.yaml"},
+		Secrets: map[string]string{"envPAT": "${{ secrets.envPAT }}"},
+	}
+	cfg.Transforms.Pin = &PinTransform{Pins: map[string]string{
+		"actions/checkout@v3":   "f43a0e5ff2bd294095638e18286ca9a3d1956744",
+		"dorny/paths-filter@v2": "4512585405083f25c027a35db413c2b3b9006d50",
+	}}
+	var out bytes.Buffer
+	assert.NoError(t, newMerger(fsys, cfg, &out).publishVersions("master", "v19", "v20.0", "v21"))
+	assert.Contains(t, out.String(), ".github/workflows/v21-ci.yml has no workflow_call trigger, the dispatcher does not call it")
+	assert.Contains(t, out.String(), "Dispatching .github/workflows/dispatch.yml to master, v20.0")
+
+	data, err := fsys.ReadFile(DefaultDispatcherFile)
+	assert.NoError(t, err)
+	assert.True(t, bytes.HasPrefix(data, []byte("# generated by yaml-merge from dispatcher of master, v20.0, do not edit\n")))
+	assert.Equal(t, `name: CI
+on:
+    push:
+    pull_request:
+    workflow_dispatch:
+jobs:
+    changes:
+        runs-on: ubuntu-latest
+        outputs:
+            master: ${{ steps.filter.outputs.master }}
+            v20_0: ${{ steps.filter.outputs.v20_0 }}
+        steps:
+            - uses: actions/checkout@f43a0e5ff2bd294095638e18286ca9a3d1956744 # v3
+            - id: filter
+              uses: dorny/paths-filter@4512585405083f25c027a35db413c2b3b9006d50 # v2
+              with:
+                filters: |
+                    master:
+                      - 'master/**'
+                      - '.github/workflows/master-*.yml'
+                      - '.github/actions/master/**'
+                    v20_0:
+                      - 'releases/v20/0/latest/**'
+                      - '.github/workflows/v20.0-*.yml'
+                      - '.github/actions/v20.0/**'
+    master:
+        needs: changes
+        if: github.event_name == 'workflow_dispatch' || needs.changes.outputs.master == 'true'
+        uses: ./.github/workflows/master-ci.yml
+        with:
+            config-path: master/yaml-merge.yaml
+        secrets:
+            envPAT: ${{ secrets.envPAT }}
+    v20_0:
+        needs: changes
+        if: github.event_name == 'workflow_dispatch' || needs.changes.outputs.v20_0 == 'true'
+        uses: ./.github/workflows/v20.0-ci.yml
+        with:
+            config-path: releases/v20/0/latest/yaml-merge.yaml
+        secrets:
+            envPAT: ${{ secrets.envPAT }}
+`, generatedBody(t, data))
+
+	// publishing one version keeps the others that are still published
+	out.Reset()
+	assert.NoError(t, newMerger(fsys, cfg, &out).publishVersions("master"))
+	assert.Contains(t, out.String(), "Dispatching .github/workflows/dispatch.yml to master, v20.0")
+
+	// pruning a version regenerates the dispatcher without it
+	assert.NoError(t, fsys.Remove("releases/v20/0/latest/build/.github/workflows/ci.yml"))
+	out.Reset()
+	m := newMerger(fsys, cfg, &out)
+	m.prune = true
+	assert.NoError(t, m.publishVersions("v20.0"))
+	assert.Contains(t, out.String(), "Pruning .github/workflows/v20.0-ci.yml")
+	assert.Contains(t, out.String(), "Dispatching .github/workflows/dispatch.yml to master \n")
+}
+
+func TestDispatcherFollowsActionPolicy(t *testing.T) {
+	fsys := newMemFS()
+	assert.NoError(t, fsys.WriteFile("master/build/.github/workflows/ci.yml", []byte("name: CI\non: workflow_call\n"), 0644))
+	cfg := defaultConfig()
+	cfg.Dispatcher = &DispatcherConfig{}
+	cfg.Transforms.Actions = &ActionPolicy{Allow: []string{"actions/*"}}
+	err := newMerger(fsys, cfg, &bytes.Buffer{}).publishVersions("master")
+	assert.ErrorContains(t, err, ".github/workflows/dispatch.yml: ")
+	assert.ErrorContains(t, err, "dorny/paths-filter@v2")
+	assert.False(t, fileExists(fsys, DefaultDispatcherFile))
+	assert.False(t, fileExists(fsys, ".github/workflows/master-ci.yml"))
+}
+
+func TestDispatcherPermissionsAndTriggers(t *testing.T) {
+	fsys := newMemFS()
+	assert.NoError(t, fsys.WriteFile("master/build/.github/workflows/ci.yml", []byte(`name: CI
+on:
+    push:
+        paths: ['master/**']
+    workflow_call: {}
+    workflow_dispatch:
+`), 0644))
+	assert.NoError(t, fsys.WriteFile("master/build/.github/workflows/docs.yml", []byte("name: Docs\non: [push, workflow_call]\n"), 0644))
+	cfg := defaultConfig()
+	cfg.Dispatcher = &DispatcherConfig{}
+	cfg.Transforms.Permissions = &PermissionsTransform{}
+	var out bytes.Buffer
+	assert.NoError(t, newMerger(fsys, cfg, &out).publishVersions("master"))
+	assert.Contains(t, out.String(), "Removing the push triggers of .github/workflows/master-ci.yml, the dispatcher calls it for them \n")
+	assert.Contains(t, out.String(), ".github/workflows/dispatch.yml: granting job changes pull-requests: read for dorny/paths-filter@v2 \n")
+
+	// only the workflow the dispatcher calls loses its triggers
+	data, err := fsys.ReadFile(".github/workflows/master-ci.yml")
+	assert.NoError(t, err)
+	assert.Equal(t, "name: CI\non:\n    workflow_call: {}\n    workflow_dispatch:\n", generatedBody(t, data))
+	data, err = fsys.ReadFile(".github/workflows/master-docs.yml")
+	assert.NoError(t, err)
+	assert.Equal(t, "name: Docs\non: [push, workflow_call]\n", generatedBody(t, data))
+
+	data, err = fsys.ReadFile(DefaultDispatcherFile)
+	assert.NoError(t, err)
+	assert.Equal(t, `name: CI
+on:
+    push:
+    pull_request:
+    workflow_dispatch:
+permissions:
+    contents: read
+jobs:
+    changes:
+        runs-on: ubuntu-latest
+        outputs:
+            master: ${{ steps.filter.outputs.master }}
+        steps:
+            - uses: actions/checkout@v3
+            - id: filter
+              uses: dorny/paths-filter@v2
+              with:
+                filters: |
+                    master:
+                      - 'master/**'
+                      - '.github/workflows/master-*.yml'
+                      - '.github/actions/master/**'
+        permissions:
+            contents: read
+            pull-requests: read
+    master:
+        needs: changes
+        if: github.event_name == 'workflow_dispatch' || needs.changes.outputs.master == 'true'
+        uses: ./.github/workflows/master-ci.yml
+`, generatedBody(t, data))
+}
+
+func TestIsReusable(t *testing.T) {
+	assert.True(t, isReusable([]byte("on: workflow_call\n")))
+	assert.True(t, isReusable([]byte("on: [push, workflow_call]\n")))
+	assert.True(t, isReusable(withGeneratedHeader([]byte("on:\n    workflow_call:\n"), "x")))
+	assert.False(t, isReusable([]byte("on: {push: {}}\n")))
+	assert.False(t, isReusable([]byte("name: CI\n")))
+}
@@ -0,0 +1,484 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// forEachExpression calls fn for every expression of a workflow or action
+// with its YAML path, e.g. jobs.build.steps[1].with.token. The values of if
+// keys are expressions even without ${{ }}. An expression missing its
+// closing braces is passed with its opening ones.
+func forEachExpression(node *yaml.Node, fn func(at, expression string)) {
+	walkExpressions(node, "", "", fn)
+}
+
+func walkExpressions(node *yaml.Node, at, key string, fn func(at, expression string)) {
+	switch node.Kind {
+	case yaml.DocumentNode:
+		for _, child := range node.Content {
+			walkExpressions(child, at, key, fn)
+		}
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			name := node.Content[i].Value
+			walkExpressions(node.Content[i+1], joinYAMLPath(at, name), name, fn)
+		}
+	case yaml.SequenceNode:
+		for i, child := range node.Content {
+			walkExpressions(child, fmt.Sprintf("%s[%d]", at, i), "", fn)
+		}
+	case yaml.ScalarNode:
+		if key == "if" && !strings.Contains(node.Value, "${{") && node.Tag != "!!bool" {
+			fn(at, node.Value)
+			return
+		}
+		for _, expression := range embeddedExpressions(node.Value) {
+			fn(at, expression)
+		}
+	}
+}
+
+// embeddedExpressions returns the expressions embedded in s with ${{ }}.
+// Braces inside string literals do not close an expression.
+func embeddedExpressions(s string) []string {
+	var expressions []string
+	for {
+		start := strings.Index(s, "${{")
+		if start < 0 {
+			return expressions
+		}
+		s = s[start+3:]
+		end := closingBraces(s)
+		if end < 0 {
+			return append(expressions, "${{"+s)
+		}
+		expressions = append(expressions, strings.TrimSpace(s[:end]))
+		s = s[end+2:]
+	}
+}
+
+// closingBraces returns the index of the }} closing an expression, or -1.
+func closingBraces(s string) int {
+	quoted := false
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '\'':
+			quoted = !quoted
+		case !quoted && strings.HasPrefix(s[i:], "}}"):
+			return i
+		}
+	}
+	return -1
+}
+
+// joinYAMLPath appends a mapping key to a YAML path.
+func joinYAMLPath(at, key string) string {
+	if at == "" {
+		return key
+	}
+	return at + "." + key
+}
+
+// contextProperties returns the properties of the secrets, vars, env and
+// inputs contexts an expression reads, as secrets.TOKEN or
+// secrets['TOKEN'], keyed by context. Expressions that do not parse read
+// nothing.
+func contextProperties(expression string) map[string][]string {
+	properties := map[string][]string{}
+	tree, err := parseExpression(expression)
+	if err != nil {
+		return properties
+	}
+	tree.walk(func(e *expr) {
+		if e.kind != exprProperty && e.kind != exprIndex {
+			return
+		}
+		object := e.args[0]
+		if object.kind != exprContext {
+			return
+		}
+		context := strings.ToLower(object.name)
+		switch context {
+		case "secrets", "vars", "env", "inputs":
+		default:
+			return
+		}
+		name := e.name
+		if e.kind == exprIndex {
+			if e.args[1].kind != exprLiteral || e.args[1].name != "string" {
+				return
+			}
+			name = e.args[1].value
+		}
+		if name != "*" {
+			properties[context] = append(properties[context], name)
+		}
+	})
+	return properties
+}
+
+// exprKind is the kind of a node of a parsed expression.
+type exprKind int
+
+const (
+	// exprLiteral is a literal; name is its type (string, number, boolean
+	// or null) and value its value.
+	exprLiteral exprKind = iota
+	// exprContext is a context such as github; name is the context.
+	exprContext
+	// exprProperty is args[0].name; name is "*" for a filter.
+	exprProperty
+	// exprIndex is args[0][args[1]].
+	exprIndex
+	// exprCall calls the function name with args.
+	exprCall
+	// exprNot is !args[0].
+	exprNot
+	// exprBinary applies the operator name to args[0] and args[1].
+	exprBinary
+)
+
+// expr is a node of a parsed expression.
+type expr struct {
+	kind  exprKind
+	name  string
+	value string
+	args  []*expr
+	// pos is the offset of the node in the expression.
+	pos int
+}
+
+// walk calls fn for e and every node below it, parents first.
+func (e *expr) walk(fn func(*expr)) {
+	fn(e)
+	for _, arg := range e.args {
+		arg.walk(fn)
+	}
+}
+
+// path returns the context access e stands for, e.g. needs.build.outputs,
+// or "" when it is not a chain of properties starting at a context.
+func (e *expr) path() string {
+	switch e.kind {
+	case exprContext:
+		return e.name
+	case exprProperty:
+		if object := e.args[0].path(); object != "" {
+			return object + "." + e.name
+		}
+	case exprIndex:
+		index := e.args[1]
+		if object := e.args[0].path(); object != "" && index.kind == exprLiteral && index.name == "string" {
+			return object + "." + index.value
+		}
+	}
+	return ""
+}
+
+// tokenKind is the kind of a token of an expression.
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenIdent
+	tokenNumber
+	tokenString
+	tokenPunct
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+func (t token) String() string {
+	switch t.kind {
+	case tokenEOF:
+		return "end of expression"
+	case tokenString:
+		return "string '" + t.text + "'"
+	}
+	return fmt.Sprintf("%q", t.text)
+}
+
+// punctuation lists the operators and delimiters, longest first.
+var punctuation = []string{"&&", "||", "==", "!=", "<=", ">=", "<", ">", "!", "(", ")", "[", "]", ".", ",", "*"}
+
+// lexExpression splits an expression into tokens.
+func lexExpression(s string) ([]token, error) {
+	var tokens []token
+	i := 0
+	for i < len(s) {
+		c := s[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '\'':
+			var b strings.Builder
+			j := i + 1
+			for {
+				if j >= len(s) {
+					return nil, fmt.Errorf("column %d: unterminated string", i+1)
+				}
+				if s[j] == '\'' {
+					if j+1 < len(s) && s[j+1] == '\'' {
+						b.WriteByte('\'')
+						j += 2
+						continue
+					}
+					break
+				}
+				b.WriteByte(s[j])
+				j++
+			}
+			tokens = append(tokens, token{tokenString, b.String(), i})
+			i = j + 1
+		case isDigit(c) || (c == '-' || c == '+') && i+1 < len(s) && (isDigit(s[i+1]) || s[i+1] == '.'):
+			j := i + 1
+			for j < len(s) && (isIdentChar(s[j]) || s[j] == '.' || (s[j] == '-' || s[j] == '+') && (s[j-1] == 'e' || s[j-1] == 'E')) {
+				j++
+			}
+			text := s[i:j]
+			if _, err := parseNumber(text); err != nil {
+				return nil, fmt.Errorf("column %d: invalid number %s", i+1, text)
+			}
+			tokens = append(tokens, token{tokenNumber, text, i})
+			i = j
+		case isIdentStart(c):
+			j := i + 1
+			for j < len(s) && isIdentChar(s[j]) {
+				j++
+			}
+			tokens = append(tokens, token{tokenIdent, s[i:j], i})
+			i = j
+		default:
+			matched := false
+			for _, p := range punctuation {
+				if strings.HasPrefix(s[i:], p) {
+					tokens = append(tokens, token{tokenPunct, p, i})
+					i += len(p)
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				return nil, fmt.Errorf("column %d: unexpected character %q", i+1, c)
+			}
+		}
+	}
+	return append(tokens, token{tokenEOF, "", len(s)}), nil
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isIdentStart(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+func isIdentChar(c byte) bool {
+	return isIdentStart(c) || isDigit(c) || c == '-'
+}
+
+// parseNumber parses a number literal: an integer, a decimal, a hex
+// number or one with an exponent.
+func parseNumber(text string) (float64, error) {
+	unsigned := strings.TrimLeft(text, "+-")
+	if strings.HasPrefix(unsigned, "0x") || strings.HasPrefix(unsigned, "0X") {
+		n, err := strconv.ParseInt(unsigned[2:], 16, 64)
+		return float64(n), err
+	}
+	return strconv.ParseFloat(text, 64)
+}
+
+// exprParser is a recursive descent parser of the expression language.
+type exprParser struct {
+	tokens []token
+	next   int
+}
+
+// parseExpression parses an expression, without its ${{ }}.
+func parseExpression(s string) (*expr, error) {
+	tokens, err := lexExpression(s)
+	if err != nil {
+		return nil, err
+	}
+	p := &exprParser{tokens: tokens}
+	if p.peek().kind == tokenEOF {
+		return nil, errors.New("empty expression")
+	}
+	e, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tokenEOF {
+		return nil, fmt.Errorf("column %d: unexpected %s", t.pos+1, t)
+	}
+	return e, nil
+}
+
+func (p *exprParser) peek() token {
+	return p.tokens[p.next]
+}
+
+// accept consumes the next token if it is the punctuation text.
+func (p *exprParser) accept(text string) (token, bool) {
+	t := p.peek()
+	if t.kind == tokenPunct && t.text == text {
+		p.next++
+		return t, true
+	}
+	return t, false
+}
+
+func (p *exprParser) expect(text string) error {
+	if t, ok := p.accept(text); !ok {
+		return fmt.Errorf("column %d: expected %q, got %s", t.pos+1, text, t)
+	}
+	return nil
+}
+
+// binary parses operands joined by any of ops with operand.
+func (p *exprParser) binary(operand func() (*expr, error), ops ...string) (*expr, error) {
+	left, err := operand()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		var op token
+		matched := false
+		for _, text := range ops {
+			if op, matched = p.accept(text); matched {
+				break
+			}
+		}
+		if !matched {
+			return left, nil
+		}
+		right, err := operand()
+		if err != nil {
+			return nil, err
+		}
+		left = &expr{kind: exprBinary, name: op.text, args: []*expr{left, right}, pos: op.pos}
+	}
+}
+
+func (p *exprParser) or() (*expr, error) {
+	return p.binary(p.and, "||")
+}
+
+func (p *exprParser) and() (*expr, error) {
+	return p.binary(p.equality, "&&")
+}
+
+func (p *exprParser) equality() (*expr, error) {
+	return p.binary(p.comparison, "==", "!=")
+}
+
+func (p *exprParser) comparison() (*expr, error) {
+	return p.binary(p.unary, "<=", ">=", "<", ">")
+}
+
+func (p *exprParser) unary() (*expr, error) {
+	if t, ok := p.accept("!"); ok {
+		operand, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return &expr{kind: exprNot, args: []*expr{operand}, pos: t.pos}, nil
+	}
+	return p.postfix()
+}
+
+func (p *exprParser) postfix() (*expr, error) {
+	e, err := p.primary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		if t, ok := p.accept("."); ok {
+			name := p.peek()
+			switch {
+			case name.kind == tokenIdent, name.kind == tokenPunct && name.text == "*":
+				p.next++
+			default:
+				return nil, fmt.Errorf("column %d: expected a property name after \".\", got %s", name.pos+1, name)
+			}
+			e = &expr{kind: exprProperty, name: name.text, args: []*expr{e}, pos: t.pos}
+			continue
+		}
+		if t, ok := p.accept("["); ok {
+			if _, ok := p.accept("*"); ok {
+				e = &expr{kind: exprProperty, name: "*", args: []*expr{e}, pos: t.pos}
+			} else {
+				index, err := p.or()
+				if err != nil {
+					return nil, err
+				}
+				e = &expr{kind: exprIndex, args: []*expr{e, index}, pos: t.pos}
+			}
+			if err := p.expect("]"); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		return e, nil
+	}
+}
+
+func (p *exprParser) primary() (*expr, error) {
+	t := p.peek()
+	switch t.kind {
+	case tokenString:
+		p.next++
+		return &expr{kind: exprLiteral, name: "string", value: t.text, pos: t.pos}, nil
+	case tokenNumber:
+		p.next++
+		return &expr{kind: exprLiteral, name: "number", value: t.text, pos: t.pos}, nil
+	case tokenIdent:
+		p.next++
+		switch t.text {
+		case "true", "false":
+			return &expr{kind: exprLiteral, name: "boolean", value: t.text, pos: t.pos}, nil
+		case "null":
+			return &expr{kind: exprLiteral, name: "null", pos: t.pos}, nil
+		case "NaN", "Infinity":
+			return &expr{kind: exprLiteral, name: "number", value: t.text, pos: t.pos}, nil
+		}
+		if _, ok := p.accept("("); !ok {
+			return &expr{kind: exprContext, name: t.text, pos: t.pos}, nil
+		}
+		call := &expr{kind: exprCall, name: t.text, pos: t.pos}
+		if _, ok := p.accept(")"); ok {
+			return call, nil
+		}
+		for {
+			arg, err := p.or()
+			if err != nil {
+				return nil, err
+			}
+			call.args = append(call.args, arg)
+			if _, ok := p.accept(","); !ok {
+				break
+			}
+		}
+		return call, p.expect(")")
+	case tokenPunct:
+		if t.text == "(" {
+			p.next++
+			e, err := p.or()
+			if err != nil {
+				return nil, err
+			}
+			return e, p.expect(")")
+		}
+	}
+	return nil, fmt.Errorf("column %d: unexpected %s", t.pos+1, t)
+}
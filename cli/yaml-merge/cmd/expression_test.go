@@ -0,0 +1,69 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// formatExpr prints a parsed expression with explicit grouping.
+func formatExpr(e *expr) string {
+	switch e.kind {
+	case exprLiteral:
+		switch e.name {
+		case "string":
+			return fmt.Sprintf("%q", e.value)
+		case "null":
+			return "null"
+		}
+		return e.value
+	case exprContext:
+		return e.name
+	case exprProperty:
+		return formatExpr(e.args[0]) + "." + e.name
+	case exprIndex:
+		return formatExpr(e.args[0]) + "[" + formatExpr(e.args[1]) + "]"
+	case exprNot:
+		return "!" + formatExpr(e.args[0])
+	case exprCall:
+		var args []string
+		for _, arg := range e.args {
+			args = append(args, formatExpr(arg))
+		}
+		return e.name + "(" + strings.Join(args, ", ") + ")"
+	}
+	return "(" + formatExpr(e.args[0]) + " " + e.name + " " + formatExpr(e.args[1]) + ")"
+}
+
+func TestParseExpression(t *testing.T) {
+	for expression, want := range map[string]string{
+		"github.event_name != 'schedule' && github.repository == 'keycloak/keycloak'": `((github.event_name != "schedule") && (github.repository == "keycloak/keycloak"))`,
+		"a || b && !c":  `(a || (b && !c))`,
+		"(a || b) && c": `((a || b) && c)`,
+		"steps.cache-maven.outputs.cache-hit != 'true'": `(steps.cache-maven.outputs.cache-hit != "true")`,
+		"fromJson(needs.db.outputs.db)[0]":              `fromJson(needs.db.outputs.db)[0]`,
+		"github.event.pull_request.labels.*.name":       `github.event.pull_request.labels.*.name`,
+		"matrix['server'] >= 0x10 || 1.5e2 < -2":        `((matrix["server"] >= 0x10) || (1.5e2 < -2))`,
+		"format('it''s {0}', null, true, NaN)":          `format("it's {0}", null, true, NaN)`,
+	} {
+		tree, err := parseExpression(expression)
+		if assert.NoError(t, err, expression) {
+			assert.Equal(t, want, formatExpr(tree), expression)
+		}
+	}
+
+	for expression, want := range map[string]string{
+		"":                 "empty expression",
+		"a ==":             "column 5: unexpected end of expression",
+		"'open":            "column 1: unterminated string",
+		"a b":              `column 3: unexpected "b"`,
+		"contains(a, b":    `column 14: expected ")", got end of expression`,
+		"github.ref = 'x'": `column 12: unexpected character '='`,
+		"1.2.3 == a":       "column 1: invalid number 1.2.3",
+	} {
+		_, err := parseExpression(expression)
+		assert.EqualError(t, err, want, expression)
+	}
+}
@@ -0,0 +1,172 @@
+package cmd
+
+import (
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+)
+
+// WritableFS is the filesystem the merge pipeline reads from and writes to.
+// Names follow the io/fs conventions: slash-separated and relative to the
+// workspace root, so the same pipeline runs against a real checkout or an
+// in-memory tree.
+type WritableFS interface {
+	fs.ReadFileFS
+	fs.ReadDirFS
+	fs.StatFS
+
+	// MkdirAll creates the named directory along with any missing parents.
+	MkdirAll(name string, perm fs.FileMode) error
+	// WriteFile writes data to the named file, creating or truncating it.
+	// Missing parent directories are created.
+	WriteFile(name string, data []byte, perm fs.FileMode) error
+	// AppendFile appends data to the named file, creating it and its parent
+	// directories if necessary.
+	AppendFile(name string, data []byte, perm fs.FileMode) error
+	// Rename moves oldname to newname, replacing newname if it exists.
+	// Missing parent directories of newname are created.
+	Rename(oldname, newname string) error
+	// Remove deletes the named file or empty directory.
+	Remove(name string) error
+}
+
+// cleanName turns a path built by the pipeline (which may start with "./"
+// or contain redundant separators) into a valid io/fs name. Absolute paths
+// and paths escaping the root are invalid.
+func cleanName(op, name string) (string, error) {
+	cleaned := path.Clean(filepath.ToSlash(name))
+	if !fs.ValidPath(cleaned) {
+		return "", &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	return cleaned, nil
+}
+
+// dirFS is a WritableFS backed by the operating system, rooted at a directory.
+type dirFS string
+
+// newDirFS returns a WritableFS rooted at dir.
+func newDirFS(dir string) WritableFS {
+	return dirFS(dir)
+}
+
+func (d dirFS) join(op, name string) (string, error) {
+	name, err := cleanName(op, name)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(string(d), filepath.FromSlash(name)), nil
+}
+
+func (d dirFS) Open(name string) (fs.File, error) {
+	full, err := d.join("open", name)
+	if err != nil {
+		return nil, err
+	}
+	return os.Open(full)
+}
+
+func (d dirFS) ReadFile(name string) ([]byte, error) {
+	full, err := d.join("read", name)
+	if err != nil {
+		return nil, err
+	}
+	return os.ReadFile(full)
+}
+
+func (d dirFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	full, err := d.join("readdir", name)
+	if err != nil {
+		return nil, err
+	}
+	return os.ReadDir(full)
+}
+
+func (d dirFS) Stat(name string) (fs.FileInfo, error) {
+	full, err := d.join("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	return os.Stat(full)
+}
+
+func (d dirFS) MkdirAll(name string, perm fs.FileMode) error {
+	full, err := d.join("mkdir", name)
+	if err != nil {
+		return err
+	}
+	return os.MkdirAll(full, perm)
+}
+
+func (d dirFS) WriteFile(name string, data []byte, perm fs.FileMode) error {
+	full, err := d.join("write", name)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(full), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(full, data, perm)
+}
+
+func (d dirFS) AppendFile(name string, data []byte, perm fs.FileMode) error {
+	full, err := d.join("append", name)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(full), 0755); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(full, os.O_CREATE|os.O_APPEND|os.O_WRONLY, perm)
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(data)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+func (d dirFS) Rename(oldname, newname string) error {
+	oldFull, err := d.join("rename", oldname)
+	if err != nil {
+		return err
+	}
+	newFull, err := d.join("rename", newname)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(newFull), 0755); err != nil {
+		return err
+	}
+	return os.Rename(oldFull, newFull)
+}
+
+func (d dirFS) Remove(name string) error {
+	full, err := d.join("remove", name)
+	if err != nil {
+		return err
+	}
+	return os.Remove(full)
+}
+
+// fileExists reports whether name exists in fsys.
+func fileExists(fsys fs.StatFS, name string) bool {
+	_, err := fsys.Stat(name)
+	return err == nil
+}
+
+// fsLogWriter is an io.Writer that appends every write to a file in a
+// WritableFS. It backs the error log so it lands under the workspace root.
+type fsLogWriter struct {
+	fsys WritableFS
+	name string
+}
+
+func (w fsLogWriter) Write(p []byte) (int, error) {
+	if err := w.fsys.AppendFile(w.name, p, 0644); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
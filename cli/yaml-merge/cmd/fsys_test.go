@@ -0,0 +1,49 @@
+package cmd
+
+import (
+	"io/fs"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWritableFSImplementations(t *testing.T) {
+	for name, fsys := range map[string]WritableFS{
+		"dir": newDirFS(t.TempDir()),
+		"mem": newMemFS(),
+	} {
+		t.Run(name, func(t *testing.T) {
+			assert.NoError(t, fsys.WriteFile("./a/b/c.yml", []byte("x: 1\n"), 0644))
+			assert.NoError(t, fsys.AppendFile("a/log", []byte("one\n"), 0644))
+			assert.NoError(t, fsys.AppendFile("a/log", []byte("two\n"), 0644))
+
+			data, err := fsys.ReadFile("a/log")
+			assert.NoError(t, err)
+			assert.Equal(t, "one\ntwo\n", string(data))
+
+			f, err := fsys.Open("./a/b/c.yml")
+			assert.NoError(t, err)
+			assert.NoError(t, f.Close())
+
+			files, err := findYAMLFiles(fsys, "a")
+			assert.NoError(t, err)
+			assert.Equal(t, []string{"a/b/c.yml"}, files)
+
+			assert.NoError(t, fsys.Rename("a/b/c.yml", "a/d.yml"))
+			assert.False(t, fileExists(fsys, "a/b/c.yml"))
+			assert.True(t, fileExists(fsys, "a/d.yml"))
+
+			assert.Error(t, fsys.Remove("a"))
+			assert.NoError(t, fsys.Remove("a/d.yml"))
+			_, err = fsys.Stat("a/d.yml")
+			assert.ErrorIs(t, err, fs.ErrNotExist)
+
+			// names cannot escape the workspace root
+			assert.ErrorIs(t, fsys.WriteFile("../escaped", []byte("x"), 0644), fs.ErrInvalid)
+			assert.ErrorIs(t, fsys.WriteFile("a/../../escaped", []byte("x"), 0644), fs.ErrInvalid)
+			assert.False(t, fileExists(fsys, "escaped"))
+			_, err = fsys.ReadFile("./a/../a/log")
+			assert.NoError(t, err)
+		})
+	}
+}
@@ -0,0 +1,162 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"io/fs"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// gitFS is what reading git metadata needs from a filesystem.
+type gitFS interface {
+	fs.ReadFileFS
+	fs.StatFS
+}
+
+// commitPattern matches a full commit SHA.
+var commitPattern = regexp.MustCompile(`^[0-9a-f]{40}$`)
+
+// upstreamCommit returns the commit of the upstream checkout at dir. A
+// checked out submodule reports the commit of its HEAD; otherwise the
+// commit recorded for dir in the superproject's index is used, so the
+// answer is the same whether or not submodules were initialised. It
+// returns "" when neither is available.
+func upstreamCommit(fsys gitFS, dir string) string {
+	if gitDir, ok := resolveGitDir(fsys, dir); ok {
+		if commit := headCommit(fsys, gitDir); commit != "" {
+			return commit
+		}
+	}
+	if gitDir, ok := resolveGitDir(fsys, "."); ok {
+		return indexGitlink(fsys, gitDir, dir)
+	}
+	return ""
+}
+
+// resolveGitDir returns the git directory of the working tree at dir. It
+// follows the "gitdir:" file a submodule has instead of a .git directory,
+// as long as the target lies inside fsys.
+func resolveGitDir(fsys gitFS, dir string) (string, bool) {
+	dotGit := path.Join(dir, ".git")
+	info, err := fsys.Stat(dotGit)
+	if err != nil {
+		return "", false
+	}
+	if info.IsDir() {
+		return dotGit, true
+	}
+	data, err := fsys.ReadFile(dotGit)
+	if err != nil {
+		return "", false
+	}
+	target := strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(string(data)), "gitdir:"))
+	if target == "" || path.IsAbs(target) {
+		return "", false
+	}
+	gitDir := path.Join(dir, target)
+	if gitDir == ".." || strings.HasPrefix(gitDir, "../") {
+		return "", false
+	}
+	return gitDir, true
+}
+
+// headCommit resolves HEAD of the repository at gitDir.
+func headCommit(fsys gitFS, gitDir string) string {
+	data, err := fsys.ReadFile(path.Join(gitDir, "HEAD"))
+	if err != nil {
+		return ""
+	}
+	head := strings.TrimSpace(string(data))
+	if ref := strings.TrimPrefix(head, "ref: "); ref != head {
+		return resolveRef(fsys, gitDir, ref)
+	}
+	if commitPattern.MatchString(head) {
+		return head
+	}
+	return ""
+}
+
+// resolveRef looks ref up as a loose ref and then in packed-refs.
+func resolveRef(fsys gitFS, gitDir, ref string) string {
+	if data, err := fsys.ReadFile(path.Join(gitDir, ref)); err == nil {
+		if commit := strings.TrimSpace(string(data)); commitPattern.MatchString(commit) {
+			return commit
+		}
+	}
+	data, err := fsys.ReadFile(path.Join(gitDir, "packed-refs"))
+	if err != nil {
+		return ""
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 2 && fields[1] == ref && commitPattern.MatchString(fields[0]) {
+			return fields[0]
+		}
+	}
+	return ""
+}
+
+// gitlinkMode is the index mode of a submodule entry.
+const gitlinkMode = 0160000
+
+// indexGitlink returns the commit the index at gitDir records for the
+// submodule at name. Index versions 2 and 3 are understood; for anything
+// else "" is returned.
+func indexGitlink(fsys gitFS, gitDir, name string) string {
+	data, err := fsys.ReadFile(path.Join(gitDir, "index"))
+	if err != nil {
+		return ""
+	}
+	commit, _ := findGitlink(data, name)
+	return commit
+}
+
+var errUnsupportedIndex = errors.New("unsupported git index")
+
+// findGitlink scans the entries of a git index for a gitlink called name.
+func findGitlink(index []byte, name string) (string, error) {
+	if len(index) < 12 || string(index[:4]) != "DIRC" {
+		return "", errUnsupportedIndex
+	}
+	version := binary.BigEndian.Uint32(index[4:8])
+	if version != 2 && version != 3 {
+		return "", errUnsupportedIndex
+	}
+	count := binary.BigEndian.Uint32(index[8:12])
+	offset := 12
+	for i := uint32(0); i < count; i++ {
+		// ctime, mtime, dev, ino, mode, uid, gid, size, sha-1, flags
+		const fixed = 62
+		if offset+fixed > len(index) {
+			return "", errUnsupportedIndex
+		}
+		entry := index[offset:]
+		mode := binary.BigEndian.Uint32(entry[24:28])
+		sha := entry[40:60]
+		flags := binary.BigEndian.Uint16(entry[60:62])
+		header := fixed
+		if flags&0x4000 != 0 {
+			// extended flags (version 3)
+			header += 2
+		}
+		end := bytes.IndexByte(entry[header:], 0)
+		if end < 0 {
+			return "", errUnsupportedIndex
+		}
+		entryName := string(entry[header : header+end])
+		if mode == gitlinkMode && entryName == name {
+			return hex.EncodeToString(sha), nil
+		}
+		// entries are NUL padded to a multiple of eight bytes
+		size := header + end + 1
+		size = (size + 7) &^ 7
+		offset += size
+	}
+	return "", nil
+}
@@ -0,0 +1,68 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type indexEntry struct {
+	name string
+	mode uint32
+	sha  string
+}
+
+// gitIndex builds a version 2 git index holding the given entries.
+func gitIndex(t *testing.T, entries ...indexEntry) []byte {
+	var b bytes.Buffer
+	b.WriteString("DIRC")
+	binary.Write(&b, binary.BigEndian, uint32(2))
+	binary.Write(&b, binary.BigEndian, uint32(len(entries)))
+	for _, e := range entries {
+		var entry bytes.Buffer
+		entry.Write(make([]byte, 24)) // ctime, mtime, dev, ino
+		binary.Write(&entry, binary.BigEndian, e.mode)
+		entry.Write(make([]byte, 12)) // uid, gid, size
+		sha, err := hex.DecodeString(e.sha)
+		assert.NoError(t, err)
+		entry.Write(sha)
+		binary.Write(&entry, binary.BigEndian, uint16(len(e.name)))
+		entry.WriteString(e.name)
+		padding := 8 - entry.Len()%8
+		entry.Write(make([]byte, padding))
+		b.Write(entry.Bytes())
+	}
+	return b.Bytes()
+}
+
+func TestUpstreamCommitFromSuperprojectIndex(t *testing.T) {
+	const blob = "e69de29bb2d1d6434b8b29ae775ad8c2e48c5391"
+	const commit = "fedcba9876543210fedcba9876543210fedcba98"
+	fsys := newMemFS()
+	assert.NoError(t, fsys.WriteFile(".git/index", gitIndex(t,
+		indexEntry{"master/.gitkeep", 0100644, blob},
+		indexEntry{"master/keycloak", gitlinkMode, commit},
+	), 0644))
+	assert.NoError(t, fsys.MkdirAll("master/keycloak", 0755))
+
+	assert.Equal(t, commit, upstreamCommit(fsys, "master/keycloak"))
+	assert.Equal(t, "", upstreamCommit(fsys, "releases/v21/latest/keycloak"))
+}
+
+func TestUpstreamCommitFromCheckout(t *testing.T) {
+	const commit = "0123456789abcdef0123456789abcdef01234567"
+	fsys := newMemFS()
+	assert.NoError(t, fsys.WriteFile("upstream/.git/HEAD", []byte(commit+"\n"), 0644))
+	assert.Equal(t, commit, upstreamCommit(fsys, "upstream"))
+
+	assert.NoError(t, fsys.WriteFile("upstream/.git/HEAD", []byte("ref: refs/heads/main\n"), 0644))
+	assert.NoError(t, fsys.WriteFile("upstream/.git/refs/heads/main", []byte(commit+"\n"), 0644))
+	assert.Equal(t, commit, upstreamCommit(fsys, "upstream"))
+
+	// a gitdir outside the workspace cannot be read
+	assert.NoError(t, fsys.WriteFile("outside/.git", []byte("gitdir: ../../elsewhere\n"), 0644))
+	assert.Equal(t, "", upstreamCommit(fsys, "outside"))
+}
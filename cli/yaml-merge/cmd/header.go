@@ -0,0 +1,182 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Every generated file starts with a header naming its inputs and the
+// checksum of the rest of the file:
+//
+//	# generated by yaml-merge from <patch> + <upstream>@<sha>, do not edit
+//	# checksum: sha256:<hex>
+//
+// A file whose content no longer matches its checksum was edited by hand.
+const (
+	generatedPrefix = "# generated by yaml-merge from "
+	generatedSuffix = ", do not edit"
+	checksumPrefix  = "# checksum: "
+)
+
+// generatedHeader is the parsed header of a generated file.
+type generatedHeader struct {
+	source   string
+	checksum string
+	body     []byte
+}
+
+// generatedSource describes the inputs of a generated file for its header.
+// The commit is left out when it is unknown, the patch when there is none.
+func generatedSource(patch, upstream, commit string) string {
+	if commit != "" {
+		upstream += "@" + commit
+	}
+	if patch == "" {
+		return upstream
+	}
+	return patch + " + " + upstream
+}
+
+// withGeneratedHeader prepends the generated-file header to body.
+func withGeneratedHeader(body []byte, source string) []byte {
+	var b bytes.Buffer
+	b.WriteString(generatedPrefix + source + generatedSuffix + "\n")
+	b.WriteString(checksumPrefix + hashBytes(body) + "\n")
+	b.Write(body)
+	return b.Bytes()
+}
+
+// parseGeneratedHeader splits a generated file into its header and body. It
+// returns false for files without a header.
+func parseGeneratedHeader(data []byte) (generatedHeader, bool) {
+	first, rest, ok := bytes.Cut(data, []byte("\n"))
+	if !ok || !bytes.HasPrefix(first, []byte(generatedPrefix)) || !bytes.HasSuffix(first, []byte(generatedSuffix)) {
+		return generatedHeader{}, false
+	}
+	second, body, ok := bytes.Cut(rest, []byte("\n"))
+	if !ok || !bytes.HasPrefix(second, []byte(checksumPrefix)) {
+		return generatedHeader{}, false
+	}
+	return generatedHeader{
+		source:   string(first[len(generatedPrefix) : len(first)-len(generatedSuffix)]),
+		checksum: string(second[len(checksumPrefix):]),
+		body:     body,
+	}, true
+}
+
+// editedByHand reports whether data, the current content of a generated
+// file, was changed after yaml-merge wrote it. Files with a header are
+// checked against their checksum; older files without one against the hash
+// recorded in the manifest, if any.
+func editedByHand(data []byte, recordedHash string) bool {
+	if header, ok := parseGeneratedHeader(data); ok {
+		return hashBytes(header.body) != header.checksum
+	}
+	return recordedHash != "" && hashBytes(data) != recordedHash
+}
+
+// manualEditError reports a generated file that was edited by hand. When
+// the edit only adds keys or list items, which is all a patch can do, it
+// suggests the patch content that would keep it.
+func manualEditError(name, patch string, edited []byte, generated *yaml.Node) error {
+	msg := fmt.Sprintf("%s was edited by hand; move the change into %s or rerun with --force", name, patch)
+	if header, ok := parseGeneratedHeader(edited); ok {
+		edited = header.body
+	}
+	var editedDoc yaml.Node
+	if err := yaml.Unmarshal(edited, &editedDoc); err != nil || editedDoc.Kind != yaml.DocumentNode || generated.Kind != yaml.DocumentNode {
+		return fmt.Errorf("%s", msg)
+	}
+	additions, conflicts := editAdditions(generated.Content[0], editedDoc.Content[0], "")
+	if len(conflicts) > 0 {
+		return fmt.Errorf("%s (changes at %s cannot be expressed as a patch)", msg, strings.Join(conflicts, ", "))
+	}
+	if additions == nil {
+		return fmt.Errorf("%s", msg)
+	}
+	suggestion, err := yaml.Marshal(additions)
+	if err != nil {
+		return fmt.Errorf("%s", msg)
+	}
+	return fmt.Errorf("%s; to keep the edit, add to %s:\n%s", msg, patch, suggestion)
+}
+
+// editAdditions returns what edited adds to generated in the shape of a
+// patch: new mapping keys and items appended to sequences. Any other
+// difference is reported by its path in conflicts. It returns nil when
+// edited adds nothing.
+func editAdditions(generated, edited *yaml.Node, at string) (additions *yaml.Node, conflicts []string) {
+	if generated.Kind != edited.Kind {
+		return nil, []string{pathOrRoot(at)}
+	}
+	switch edited.Kind {
+	case yaml.MappingNode:
+		result := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+		generatedIndex, err := newMappingIndex(generated)
+		if err != nil {
+			return nil, []string{pathOrRoot(at)}
+		}
+		editedIndex, err := newMappingIndex(edited)
+		if err != nil {
+			return nil, []string{pathOrRoot(at)}
+		}
+		for i := 0; i < len(generated.Content); i += 2 {
+			if _, ok := editedIndex.find(generated.Content[i].Value); !ok {
+				conflicts = append(conflicts, at+"."+generated.Content[i].Value)
+			}
+		}
+		for i := 0; i < len(edited.Content); i += 2 {
+			key, value := edited.Content[i], edited.Content[i+1]
+			j, ok := generatedIndex.find(key.Value)
+			if !ok {
+				result.Content = append(result.Content, key, value)
+				continue
+			}
+			sub, subConflicts := editAdditions(generated.Content[j+1], value, at+"."+key.Value)
+			conflicts = append(conflicts, subConflicts...)
+			if sub != nil {
+				result.Content = append(result.Content, key, sub)
+			}
+		}
+		if len(result.Content) == 0 {
+			return nil, conflicts
+		}
+		return result, conflicts
+	case yaml.SequenceNode:
+		if len(edited.Content) < len(generated.Content) {
+			return nil, []string{pathOrRoot(at)}
+		}
+		for i, item := range generated.Content {
+			if !sameYAML(item, edited.Content[i]) {
+				return nil, []string{at + "[" + strconv.Itoa(i) + "]"}
+			}
+		}
+		if len(edited.Content) == len(generated.Content) {
+			return nil, nil
+		}
+		return &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq", Content: edited.Content[len(generated.Content):]}, nil
+	default:
+		if !sameYAML(generated, edited) {
+			return nil, []string{pathOrRoot(at)}
+		}
+		return nil, nil
+	}
+}
+
+// sameYAML reports whether two nodes encode to the same YAML.
+func sameYAML(a, b *yaml.Node) bool {
+	aData, aErr := yaml.Marshal(a)
+	bData, bErr := yaml.Marshal(b)
+	return aErr == nil && bErr == nil && bytes.Equal(aData, bData)
+}
+
+func pathOrRoot(at string) string {
+	if at == "" {
+		return "."
+	}
+	return at
+}
@@ -0,0 +1,89 @@
+package cmd
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v3"
+)
+
+// generatedBody checks that data carries a valid generated-file header and
+// returns the content below it.
+func generatedBody(t *testing.T, data []byte) string {
+	t.Helper()
+	header, ok := parseGeneratedHeader(data)
+	if !assert.True(t, ok, "missing generated-file header") {
+		return string(data)
+	}
+	assert.Equal(t, hashBytes(header.body), header.checksum)
+	return string(header.body)
+}
+
+func TestGeneratedHeader(t *testing.T) {
+	data := withGeneratedHeader([]byte("name: CI\n"), generatedSource("master/patches/ci.yml", "master/keycloak/ci.yml", "abc123"))
+	assert.True(t, bytes.HasPrefix(data, []byte("# generated by yaml-merge from master/patches/ci.yml + master/keycloak/ci.yml@abc123, do not edit\n# checksum: sha256:")))
+
+	header, ok := parseGeneratedHeader(data)
+	assert.True(t, ok)
+	assert.Equal(t, "master/patches/ci.yml + master/keycloak/ci.yml@abc123", header.source)
+	assert.Equal(t, "name: CI\n", string(header.body))
+	assert.False(t, editedByHand(data, ""))
+
+	edited := append(append([]byte(nil), data...), "env: {A: 1}\n"...)
+	assert.True(t, editedByHand(edited, ""))
+
+	// files without a header are checked against the manifest
+	assert.False(t, editedByHand([]byte("name: CI\n"), ""))
+	assert.False(t, editedByHand([]byte("name: CI\n"), hashBytes([]byte("name: CI\n"))))
+	assert.True(t, editedByHand([]byte("name: Mine\n"), hashBytes([]byte("name: CI\n"))))
+
+	assert.Equal(t, "a + b", generatedSource("a", "b", ""))
+}
+
+func TestManualEditsAreRefused(t *testing.T) {
+	const output = "master/build/.github/workflows/master-ci.yml"
+	fsys := newMemFS()
+	assert.NoError(t, fsys.WriteFile("master/keycloak/.github/workflows/master-ci.yml", []byte("name: CI\njobs:\n    build:\n        steps:\n            - run: build\n"), 0644))
+	assert.NoError(t, fsys.WriteFile("master/patches/.github/workflows/master-ci.yml", []byte("env: {A: 1}\n"), 0644))
+	assert.NoError(t, newMerger(fsys, defaultConfig(), io.Discard).mergeVersions("master"))
+
+	generated, err := fsys.ReadFile(output)
+	assert.NoError(t, err)
+	edited := bytes.Replace(generated, []byte("            - run: build\n"), []byte("            - run: build\n            - run: test\n"), 1)
+	edited = append(edited, "permissions: {}\n"...)
+	assert.NoError(t, fsys.WriteFile(output, edited, 0644))
+
+	err = newMerger(fsys, defaultConfig(), io.Discard).mergeVersions("master")
+	assert.ErrorContains(t, err, output+" was edited by hand; move the change into master/patches/.github/workflows/master-ci.yml or rerun with --force")
+	assert.ErrorContains(t, err, "jobs:\n    build:\n        steps:\n            - run: test\npermissions: {}\n")
+	data, _ := fsys.ReadFile(output)
+	assert.Equal(t, edited, data)
+
+	m := newMerger(fsys, defaultConfig(), io.Discard)
+	m.force = true
+	assert.NoError(t, m.mergeVersions("master"))
+	data, _ = fsys.ReadFile(output)
+	assert.Equal(t, generated, data)
+}
+
+func TestEditAdditions(t *testing.T) {
+	parse := func(s string) *yaml.Node {
+		var doc yaml.Node
+		assert.NoError(t, yaml.Unmarshal([]byte(s), &doc))
+		return doc.Content[0]
+	}
+	additions, conflicts := editAdditions(parse("a: 1\nb: [x]\n"), parse("a: 2\nb: [y, z]\nc: 3\n"), "")
+	assert.Equal(t, []string{".a", ".b[0]"}, conflicts)
+	data, err := yaml.Marshal(additions)
+	assert.NoError(t, err)
+	assert.Equal(t, "c: 3\n", string(data))
+
+	_, conflicts = editAdditions(parse("a: 1\nb: 2\n"), parse("a: 1\n"), "")
+	assert.Equal(t, []string{".b"}, conflicts)
+
+	additions, conflicts = editAdditions(parse("a: 1\n"), parse("a: 1\n"), "")
+	assert.Nil(t, additions)
+	assert.Empty(t, conflicts)
+}
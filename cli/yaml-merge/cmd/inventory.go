@@ -0,0 +1,267 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+// inventoryFormat is the output format of the inventory command.
+var inventoryFormat string
+
+// inventoryCmd lists the secrets, variables, environment, inputs and
+// outputs of the merged files of every selected version.
+var inventoryCmd = &cobra.Command{
+	Use:   "inventory <version>...",
+	Short: "list the secrets, variables and environment merged workflows use",
+	Long: `Inventory reads the merged workflows and actions below a version's build folder and lists, per version, the secrets, configuration variables (vars), environment variables, inputs and outputs they declare or read in expressions, with the files using each. Secrets a reusable workflow reads without declaring them under on.workflow_call.secrets are flagged.
+
+The inventory is printed as Markdown tables, or as JSON with --format json.`,
+	Args: cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if inventoryFormat != "markdown" && inventoryFormat != "json" {
+			return fmt.Errorf("unknown format %q, want markdown or json", inventoryFormat)
+		}
+		fsys := newDirFS(rootDir)
+		cfg, err := loadConfig(fsys, configFile, cmd.Flags().Changed("config"))
+		if err != nil {
+			return err
+		}
+		inventory, err := buildInventory(fsys, cfg.Layout, args...)
+		if err != nil {
+			return err
+		}
+		if inventoryFormat == "json" {
+			return inventory.writeJSON(cmd.OutOrStdout())
+		}
+		return inventory.writeMarkdown(cmd.OutOrStdout())
+	},
+}
+
+// Kinds of inventory entries, in the order they are listed.
+const (
+	InventorySecret = "secret"
+	InventoryVar    = "var"
+	InventoryEnv    = "env"
+	InventoryInput  = "input"
+	InventoryOutput = "output"
+)
+
+var inventoryKinds = []string{InventorySecret, InventoryVar, InventoryEnv, InventoryInput, InventoryOutput}
+
+// Inventory lists what the merged files of some versions use.
+type Inventory struct {
+	Versions []VersionInventory `json:"versions"`
+}
+
+// VersionInventory lists what the merged files of one version use. Files
+// are relative to the version's build folder.
+type VersionInventory struct {
+	Version string           `json:"version"`
+	Entries []InventoryEntry `json:"entries"`
+}
+
+// InventoryEntry is one secret, variable, input or output and the files
+// declaring or reading it. Undeclared lists the reusable workflows that
+// read a secret without declaring it.
+type InventoryEntry struct {
+	Kind       string   `json:"kind"`
+	Name       string   `json:"name"`
+	Files      []string `json:"files"`
+	Undeclared []string `json:"undeclared,omitempty"`
+}
+
+// buildInventory collects the inventory of every channel the selectors
+// resolve to.
+func buildInventory(fsys WritableFS, layout Layout, selectors ...string) (*Inventory, error) {
+	channels, err := resolveSelectors(fsys, layout, selectors)
+	if err != nil {
+		return nil, err
+	}
+	inventory := &Inventory{Versions: []VersionInventory{}}
+	for _, channel := range channels {
+		version, err := channelInventory(fsys, channel)
+		if err != nil {
+			return nil, err
+		}
+		inventory.Versions = append(inventory.Versions, version)
+	}
+	return inventory, nil
+}
+
+// channelInventory collects the inventory of the merged files of channel.
+func channelInventory(fsys WritableFS, channel Channel) (VersionInventory, error) {
+	version := VersionInventory{Version: channelSelector(channel), Entries: []InventoryEntry{}}
+	files, err := findYAMLFiles(fsys, channel.Output)
+	if errors.Is(err, fs.ErrNotExist) {
+		return version, nil
+	}
+	if err != nil {
+		return version, err
+	}
+	entries := map[string]*InventoryEntry{}
+	entry := func(kind, name string) *InventoryEntry {
+		key := kind + "\x00" + name
+		if entries[key] == nil {
+			entries[key] = &InventoryEntry{Kind: kind, Name: name}
+		}
+		return entries[key]
+	}
+	for _, file := range files {
+		data, err := fsys.ReadFile(file)
+		if err != nil {
+			return version, err
+		}
+		var doc yaml.Node
+		if err := yaml.Unmarshal(data, &doc); err != nil {
+			return version, fmt.Errorf("parsing %s: %w", file, err)
+		}
+		name := strings.TrimPrefix(file, channel.Output+"/")
+		used := fileInventory(&doc)
+		declared := declaredSecrets(&doc)
+		for _, kind := range inventoryKinds {
+			for _, property := range used[kind] {
+				e := entry(kind, property)
+				e.Files = appendUnique(e.Files, name)
+				if kind == InventorySecret && declared != nil && !declared[property] && property != "GITHUB_TOKEN" {
+					e.Undeclared = appendUnique(e.Undeclared, name)
+				}
+			}
+		}
+	}
+	for _, e := range entries {
+		version.Entries = append(version.Entries, *e)
+	}
+	order := map[string]int{}
+	for i, kind := range inventoryKinds {
+		order[kind] = i
+	}
+	sort.Slice(version.Entries, func(i, j int) bool {
+		a, b := version.Entries[i], version.Entries[j]
+		if a.Kind != b.Kind {
+			return order[a.Kind] < order[b.Kind]
+		}
+		return a.Name < b.Name
+	})
+	return version, nil
+}
+
+// fileInventory returns the names a merged file declares or reads, by
+// kind.
+func fileInventory(doc *yaml.Node) map[string][]string {
+	used := map[string][]string{}
+	add := func(kind string, names ...string) {
+		for _, name := range names {
+			used[kind] = appendUnique(used[kind], name)
+		}
+	}
+	contexts := map[string]string{"secrets": InventorySecret, "vars": InventoryVar, "env": InventoryEnv, "inputs": InventoryInput}
+	forEachExpression(doc, func(_, expression string) {
+		for context, names := range contextProperties(expression) {
+			add(contexts[context], names...)
+		}
+	})
+
+	root := documentRoot(doc)
+	on := mappingValue(root, "on")
+	for _, event := range []string{"workflow_call", "workflow_dispatch"} {
+		add(InventoryInput, mappingKeys(mappingValue(mappingValue(on, event), "inputs"))...)
+	}
+	add(InventorySecret, mappingKeys(mappingValue(mappingValue(on, "workflow_call"), "secrets"))...)
+	add(InventoryOutput, mappingKeys(mappingValue(mappingValue(on, "workflow_call"), "outputs"))...)
+	add(InventoryInput, mappingKeys(mappingValue(root, "inputs"))...)
+	add(InventoryOutput, mappingKeys(mappingValue(root, "outputs"))...)
+
+	add(InventoryEnv, mappingKeys(mappingValue(root, "env"))...)
+	forEachJob(root, func(_ string, job *yaml.Node) {
+		add(InventoryEnv, mappingKeys(mappingValue(job, "env"))...)
+		add(InventoryOutput, mappingKeys(mappingValue(job, "outputs"))...)
+	})
+	forEachStep(root, func(step *yaml.Node) {
+		add(InventoryEnv, mappingKeys(mappingValue(step, "env"))...)
+	})
+	for kind := range used {
+		sort.Strings(used[kind])
+	}
+	return used
+}
+
+// declaredSecrets returns the secrets a reusable workflow declares, or nil
+// when doc is not a reusable workflow.
+func declaredSecrets(doc *yaml.Node) map[string]bool {
+	root := documentRoot(doc)
+	if !isReusableWorkflow(root) {
+		return nil
+	}
+	declared := map[string]bool{}
+	call := mappingValue(mappingValue(root, "on"), "workflow_call")
+	for _, name := range mappingKeys(mappingValue(call, "secrets")) {
+		declared[name] = true
+	}
+	return declared
+}
+
+// mappingKeys returns the keys of a mapping node in order.
+func mappingKeys(node *yaml.Node) []string {
+	var keys []string
+	mappingEntries(node, func(key string, _ *yaml.Node) {
+		keys = append(keys, key)
+	})
+	return keys
+}
+
+// appendUnique appends value to values unless it is there already.
+func appendUnique(values []string, value string) []string {
+	for _, v := range values {
+		if v == value {
+			return values
+		}
+	}
+	return append(values, value)
+}
+
+// writeJSON prints the inventory as indented JSON.
+func (inv *Inventory) writeJSON(w io.Writer) error {
+	data, err := json.MarshalIndent(inv, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "%s\n", data)
+	return err
+}
+
+// writeMarkdown prints the inventory as one table per version.
+func (inv *Inventory) writeMarkdown(w io.Writer) error {
+	var b strings.Builder
+	b.WriteString("# Inventory\n")
+	for _, version := range inv.Versions {
+		fmt.Fprintf(&b, "\n## %s\n\n", version.Version)
+		if len(version.Entries) == 0 {
+			b.WriteString("No merged files.\n")
+			continue
+		}
+		b.WriteString("| Kind | Name | Files | Notes |\n")
+		b.WriteString("| --- | --- | --- | --- |\n")
+		for _, e := range version.Entries {
+			var notes string
+			if len(e.Undeclared) > 0 {
+				notes = "not declared in workflow_call.secrets of " + strings.Join(e.Undeclared, ", ")
+			}
+			fmt.Fprintf(&b, "| %s | `%s` | %s | %s |\n", e.Kind, e.Name, strings.Join(e.Files, ", "), notes)
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func init() {
+	inventoryCmd.Flags().StringVar(&inventoryFormat, "format", "markdown", "output format, markdown or json")
+	rootCmd.AddCommand(inventoryCmd)
+}
@@ -0,0 +1,120 @@
+package cmd
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v3"
+)
+
+func TestInventory(t *testing.T) {
+	fsys := newMemFS()
+	assert.NoError(t, fsys.WriteFile("master/build/.github/workflows/ci.yml", withGeneratedHeader([]byte(`on:
+    workflow_call:
+        inputs:
+            version: {type: string}
+        secrets:
+            envPAT: {required: true}
+env:
+    MAVEN_ARGS: -B
+jobs:
+    build:
+        if: secrets.SSH_KEY != ''
+        outputs:
+            matrix: ${{ steps.matrix.outputs.value }}
+        steps:
+            - uses: webfactory/ssh-agent@v0.7.0
+              with:
+                  ssh-private-key: ${{ secrets.SSH_KEY }}
+            - run: echo "${{ inputs.version }} ${{ vars.REGISTRY }} ${{ env.MAVEN_ARGS }}"
+              env:
+                  TOKEN: ${{ secrets['envPAT'] }}
+                  NOTE: ${{ format('secrets.{0}', github.token) }}
+            - uses: runforesight/foresight-test-kit-action@v1.2.1
+              with:
+                  api_key: ${{ secrets.FORESIGHT_API_KEY }}
+                  token: ${{ secrets.GITHUB_TOKEN }}
+`), "test"), 0644))
+	assert.NoError(t, fsys.WriteFile("master/build/.github/actions/build/action.yml", []byte(`inputs:
+    maven-args: {default: ""}
+outputs:
+    version: {value: "${{ steps.v.outputs.version }}"}
+runs:
+    using: composite
+    steps:
+        - run: mvn ${{ inputs.maven-args }}
+          shell: bash
+`), 0644))
+	assert.NoError(t, fsys.MkdirAll("releases/v21/latest/keycloak", 0755))
+
+	inventory, err := buildInventory(fsys, defaultConfig().Layout, "master", "v21")
+	assert.NoError(t, err)
+
+	var out bytes.Buffer
+	assert.NoError(t, inventory.writeMarkdown(&out))
+	assert.Equal(t, "# Inventory\n"+
+		"\n## master\n\n"+
+		"| Kind | Name | Files | Notes |\n"+
+		"| --- | --- | --- | --- |\n"+
+		"| secret | `FORESIGHT_API_KEY` | .github/workflows/ci.yml | not declared in workflow_call.secrets of .github/workflows/ci.yml |\n"+
+		"| secret | `GITHUB_TOKEN` | .github/workflows/ci.yml |  |\n"+
+		"| secret | `SSH_KEY` | .github/workflows/ci.yml | not declared in workflow_call.secrets of .github/workflows/ci.yml |\n"+
+		"| secret | `envPAT` | .github/workflows/ci.yml |  |\n"+
+		"| var | `REGISTRY` | .github/workflows/ci.yml |  |\n"+
+		"| env | `MAVEN_ARGS` | .github/workflows/ci.yml |  |\n"+
+		"| env | `NOTE` | .github/workflows/ci.yml |  |\n"+
+		"| env | `TOKEN` | .github/workflows/ci.yml |  |\n"+
+		"| input | `maven-args` | .github/actions/build/action.yml |  |\n"+
+		"| input | `version` | .github/workflows/ci.yml |  |\n"+
+		"| output | `matrix` | .github/workflows/ci.yml |  |\n"+
+		"| output | `version` | .github/actions/build/action.yml |  |\n"+
+		"\n## v21\n\n"+
+		"No merged files.\n", out.String())
+
+	out.Reset()
+	assert.NoError(t, inventory.writeJSON(&out))
+	assert.Contains(t, out.String(), `{
+      "version": "v21",
+      "entries": []
+    }`)
+	assert.Contains(t, out.String(), `{
+          "kind": "secret",
+          "name": "SSH_KEY",
+          "files": [
+            ".github/workflows/ci.yml"
+          ],
+          "undeclared": [
+            ".github/workflows/ci.yml"
+          ]
+        }`)
+}
+
+func TestContextProperties(t *testing.T) {
+	assert.Equal(t, map[string][]string{
+		"secrets": {"A", "B"},
+		"env":     {"C"},
+	}, contextProperties(`secrets.A || secrets[ 'B' ] || env.C || steps.x.outputs.env || 'secrets.D'`))
+}
+
+func TestForEachExpression(t *testing.T) {
+	var doc yaml.Node
+	assert.NoError(t, yaml.Unmarshal([]byte(`jobs:
+    build:
+        if: github.event_name == 'push'
+        steps:
+            - run: echo ${{ a }} ${{b}}
+              if: ${{ c }}
+              continue-on-error: true
+`), &doc))
+	var found []string
+	forEachExpression(&doc, func(at, expression string) {
+		found = append(found, at+": "+expression)
+	})
+	assert.Equal(t, []string{
+		"jobs.build.if: github.event_name == 'push'",
+		"jobs.build.steps[0].run: a",
+		"jobs.build.steps[0].run: b",
+		"jobs.build.steps[0].if: c",
+	}, found)
+}
@@ -0,0 +1,175 @@
+package cmd
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// JobsSpec selects the jobs kept in the workflows it matches. A job is
+// selected when it matches an Include glob, or Include is empty, and no
+// Exclude glob. The jobs a selected job needs are kept as well; needing an
+// excluded job is an error, and needs naming a job that does not exist are
+// removed.
+type JobsSpec struct {
+	WorkflowSelector `yaml:",inline"`
+	// Include lists globs of the job ids to keep.
+	Include []string `yaml:"include"`
+	// Exclude lists globs of the job ids to drop.
+	Exclude []string `yaml:"exclude"`
+}
+
+// validate checks the globs of the spec.
+func (s JobsSpec) validate() error {
+	if err := s.WorkflowSelector.validate(); err != nil {
+		return err
+	}
+	if err := validateGlobs(s.Include); err != nil {
+		return fmt.Errorf("include: %w", err)
+	}
+	if err := validateGlobs(s.Exclude); err != nil {
+		return fmt.Errorf("exclude: %w", err)
+	}
+	return nil
+}
+
+// jobsTransform selects the jobs of workflows with the first spec matching
+// each of them.
+type jobsTransform []JobsSpec
+
+// apply drops the jobs of a workflow its spec does not select.
+func (specs jobsTransform) apply(ctx transformContext, doc *yaml.Node) error {
+	root := documentRoot(doc)
+	if root == nil || !ctx.isWorkflow() {
+		return nil
+	}
+	for _, spec := range specs {
+		if spec.matches(ctx.channel, ctx.path) {
+			return spec.apply(ctx, root)
+		}
+	}
+	return nil
+}
+
+// matchingGlob returns the first glob matching name, or "".
+func matchingGlob(globs []string, name string) string {
+	for _, glob := range globs {
+		if ok, _ := path.Match(glob, name); ok {
+			return glob
+		}
+	}
+	return ""
+}
+
+// apply removes the jobs of root that the spec does not select, keeping
+// the dependency closure of the selected ones.
+func (s JobsSpec) apply(ctx transformContext, root *yaml.Node) error {
+	jobs := mappingValue(root, "jobs")
+	if jobs == nil || jobs.Kind != yaml.MappingNode {
+		return nil
+	}
+	needs := map[string][]string{}
+	var ids []string
+	forEachJob(root, func(id string, job *yaml.Node) {
+		ids = append(ids, id)
+		needs[id] = jobNeeds(job)
+	})
+
+	// why each job is dropped; selected jobs have no entry
+	dropped := map[string]string{}
+	// the glob excluding each excluded job
+	excluded := map[string]string{}
+	for _, id := range ids {
+		if glob := matchingGlob(s.Exclude, id); glob != "" {
+			dropped[id] = "excluded by " + glob
+			excluded[id] = glob
+		} else if len(s.Include) > 0 && matchingGlob(s.Include, id) == "" {
+			dropped[id] = "not included"
+		}
+	}
+
+	// keep the dependency closure of the selected jobs
+	var queue []string
+	for _, id := range ids {
+		if _, ok := dropped[id]; !ok {
+			queue = append(queue, id)
+		}
+	}
+	for len(queue) > 0 {
+		id := queue[0]
+		queue = queue[1:]
+		for _, need := range needs[id] {
+			if glob, ok := excluded[need]; ok {
+				return fmt.Errorf("job %s is needed by %s but excluded by %s", need, id, glob)
+			}
+			if reason, ok := dropped[need]; ok {
+				ctx.report("keeping job %s (%s): needed by %s", need, reason, id)
+				delete(dropped, need)
+				queue = append(queue, need)
+			}
+		}
+	}
+
+	for _, id := range ids {
+		if reason, ok := dropped[id]; ok {
+			ctx.report("dropping job %s: %s", id, reason)
+			removeMappingKey(jobs, id)
+		}
+	}
+	forEachJob(root, func(id string, job *yaml.Node) {
+		removeDanglingNeeds(ctx, id, job, jobs)
+	})
+	return nil
+}
+
+// jobNeeds returns the ids of the jobs a job needs.
+func jobNeeds(job *yaml.Node) []string {
+	needs := mappingValue(job, "needs")
+	switch {
+	case needs == nil:
+		return nil
+	case needs.Kind == yaml.ScalarNode:
+		return []string{needs.Value}
+	}
+	var ids []string
+	for _, need := range needs.Content {
+		ids = append(ids, need.Value)
+	}
+	return ids
+}
+
+// removeDanglingNeeds removes the needs of job naming a job that is not in
+// jobs, and the needs key when none are left.
+func removeDanglingNeeds(ctx transformContext, id string, job, jobs *yaml.Node) {
+	needs := mappingValue(job, "needs")
+	if needs == nil {
+		return
+	}
+	var dangling []string
+	if needs.Kind == yaml.ScalarNode {
+		if mappingValue(jobs, needs.Value) == nil {
+			dangling = append(dangling, needs.Value)
+			removeMappingKey(job, "needs")
+		}
+	} else {
+		kept := needs.Content[:0]
+		for _, need := range needs.Content {
+			if mappingValue(jobs, need.Value) == nil {
+				dangling = append(dangling, need.Value)
+				continue
+			}
+			kept = append(kept, need)
+		}
+		needs.Content = kept
+		if len(kept) == 0 {
+			removeMappingKey(job, "needs")
+		}
+	}
+	if len(dangling) > 0 {
+		sort.Strings(dangling)
+		ctx.report("removing needs %s of job %s: no such job", strings.Join(dangling, ", "), id)
+	}
+}
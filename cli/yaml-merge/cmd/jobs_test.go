@@ -0,0 +1,85 @@
+package cmd
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v3"
+)
+
+func TestJobsTransform(t *testing.T) {
+	var doc yaml.Node
+	assert.NoError(t, yaml.Unmarshal([]byte(`name: CI
+jobs:
+    conditional:
+        runs-on: ubuntu-latest
+    build:
+        needs: conditional
+    unit-tests:
+        needs: [build]
+    base-integration-tests:
+        needs: [build, conditional]
+    quarkus-integration-tests:
+        needs: build
+    webauthn-integration-tests:
+        needs: build
+    check:
+        needs: [unit-tests, base-integration-tests, quarkus-integration-tests, gone]
+    docs:
+        runs-on: ubuntu-latest
+`), &doc))
+
+	var out bytes.Buffer
+	ctx := transformContext{channel: Channel{Name: "master"}, path: "master/build/.github/workflows/ci.yml", out: &out}
+	transform := jobsTransform{
+		{WorkflowSelector: WorkflowSelector{Match: "v{major}"}, Include: []string{"nothing"}},
+		{Include: []string{"unit-tests", "*-integration-tests", "check"}, Exclude: []string{"webauthn-*"}},
+	}
+	assert.NoError(t, transform.apply(ctx, &doc))
+
+	data, err := yaml.Marshal(&doc)
+	assert.NoError(t, err)
+	assert.Equal(t, `name: CI
+jobs:
+    conditional:
+        runs-on: ubuntu-latest
+    build:
+        needs: conditional
+    unit-tests:
+        needs: [build]
+    base-integration-tests:
+        needs: [build, conditional]
+    quarkus-integration-tests:
+        needs: build
+    check:
+        needs: [unit-tests, base-integration-tests, quarkus-integration-tests]
+`, string(data))
+	assert.Equal(t, ""+
+		"master/build/.github/workflows/ci.yml: keeping job build (not included): needed by unit-tests \n"+
+		"master/build/.github/workflows/ci.yml: keeping job conditional (not included): needed by base-integration-tests \n"+
+		"master/build/.github/workflows/ci.yml: dropping job webauthn-integration-tests: excluded by webauthn-* \n"+
+		"master/build/.github/workflows/ci.yml: dropping job docs: not included \n"+
+		"master/build/.github/workflows/ci.yml: removing needs gone of job check: no such job \n",
+		out.String())
+}
+
+func TestJobsTransformExcludingANeededJob(t *testing.T) {
+	var doc yaml.Node
+	assert.NoError(t, yaml.Unmarshal([]byte(`jobs:
+    build:
+        runs-on: ubuntu-latest
+    unit-tests:
+        needs: build
+    check:
+        needs: [unit-tests]
+`), &doc))
+	ctx := transformContext{channel: Channel{Name: "master"}, path: "master/build/.github/workflows/ci.yml", out: &bytes.Buffer{}}
+	transform := jobsTransform{{Include: []string{"check"}, Exclude: []string{"build"}}}
+	assert.EqualError(t, transform.apply(ctx, &doc), "job build is needed by unit-tests but excluded by build")
+}
+
+func TestJobsSpecValidation(t *testing.T) {
+	assert.NoError(t, JobsSpec{Include: []string{"*-tests"}}.validate())
+	assert.Error(t, JobsSpec{Exclude: []string{"["}}.validate())
+}
@@ -0,0 +1,242 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// Default directory names inside a channel root, used for every field a
+// ChannelLayout leaves empty.
+const (
+	DefaultUpstreamDir  = "keycloak"
+	DefaultPatchesDir   = "patches"
+	DefaultOverridesDir = "overrides"
+	DefaultCicdDir      = "cicd"
+	DefaultOutputDir    = "build"
+)
+
+// Layout maps release channels ("master", "nightly", "v21", "v20/0") to the
+// directories holding their upstream checkout, patches, overrides, cicd copy
+// and generated output.
+type Layout struct {
+	// Channels are tried in order before the built-in defaults, so a
+	// configuration only has to list the channels it changes.
+	Channels []ChannelLayout `yaml:"channels"`
+}
+
+// ChannelLayout describes the directories of every channel whose name
+// matches Match. Match and Root may contain the {major} and {minor}
+// placeholders, which stand for a run of digits; the values captured from
+// the channel name are substituted into Root. The directory fields are
+// relative to Root.
+type ChannelLayout struct {
+	Match     string `yaml:"match"`
+	Root      string `yaml:"root"`
+	Upstream  string `yaml:"upstream"`
+	Patches   string `yaml:"patches"`
+	Overrides string `yaml:"overrides"`
+	Cicd      string `yaml:"cicd"`
+	Output    string `yaml:"output"`
+}
+
+// defaultChannelLayouts is the layout of this repository: master at the
+// top level and the releases below releases/<channel>/latest.
+var defaultChannelLayouts = []ChannelLayout{
+	{Match: "master", Root: "master", Cicd: "baseline"},
+	{Match: "nightly", Root: "releases/nightly"},
+	{Match: "v{major}/{minor}", Root: "releases/v{major}/{minor}/latest"},
+	{Match: "v{major}", Root: "releases/v{major}/latest"},
+}
+
+// Channel is a release channel resolved through the layout. All paths are
+// relative to the workspace root. Major and Minor hold the version numbers
+// captured from the name and are empty for channels such as master.
+type Channel struct {
+	Name      string
+	Major     string
+	Minor     string
+	Root      string
+	Upstream  string
+	Patches   string
+	Overrides string
+	Cicd      string
+	Output    string
+}
+
+var placeholderPattern = regexp.MustCompile(`\{(major|minor)\}`)
+
+// channelLayouts returns the configured channel layouts followed by the
+// defaults.
+func (l Layout) channelLayouts() []ChannelLayout {
+	return append(append([]ChannelLayout(nil), l.Channels...), defaultChannelLayouts...)
+}
+
+// validate checks that every configured channel has a usable pattern.
+func (l Layout) validate() error {
+	for i, c := range l.Channels {
+		if c.Match == "" || c.Root == "" {
+			return fmt.Errorf("layout.channels[%d]: match and root are required", i)
+		}
+		for _, name := range placeholderPattern.FindAllStringSubmatch(c.Root, -1) {
+			if !strings.Contains(c.Match, name[0]) {
+				return fmt.Errorf("layout.channels[%d]: root uses %s which match does not capture", i, name[0])
+			}
+		}
+	}
+	return nil
+}
+
+// Resolve returns the channel called name, or an error when no channel
+// layout matches it.
+func (l Layout) Resolve(name string) (Channel, error) {
+	for _, c := range l.channelLayouts() {
+		values, ok := matchPlaceholders(c.Match, name)
+		if !ok {
+			continue
+		}
+		return c.channel(name, values), nil
+	}
+	return Channel{}, errors.New(fmt.Sprintf("No layout for channel: %s", name))
+}
+
+// channel builds the Channel called name from the captured placeholder values.
+func (c ChannelLayout) channel(name string, values map[string]string) Channel {
+	root := expandPlaceholders(c.Root, values)
+	dir := func(configured, fallback string) string {
+		if configured == "" {
+			configured = fallback
+		}
+		return path.Join(root, configured)
+	}
+	return Channel{
+		Name:      name,
+		Major:     values["major"],
+		Minor:     values["minor"],
+		Root:      root,
+		Upstream:  dir(c.Upstream, DefaultUpstreamDir),
+		Patches:   dir(c.Patches, DefaultPatchesDir),
+		Overrides: dir(c.Overrides, DefaultOverridesDir),
+		Cicd:      dir(c.Cicd, DefaultCicdDir),
+		Output:    dir(c.Output, DefaultOutputDir),
+	}
+}
+
+// placeholderRegexp compiles a pattern with {major}/{minor} placeholders
+// into an anchored regular expression with one named group per placeholder.
+func placeholderRegexp(pattern string) *regexp.Regexp {
+	var b strings.Builder
+	b.WriteString("^")
+	last := 0
+	for _, loc := range placeholderPattern.FindAllStringSubmatchIndex(pattern, -1) {
+		b.WriteString(regexp.QuoteMeta(pattern[last:loc[0]]))
+		b.WriteString(fmt.Sprintf(`(?P<%s>\d+)`, pattern[loc[2]:loc[3]]))
+		last = loc[1]
+	}
+	b.WriteString(regexp.QuoteMeta(pattern[last:]))
+	b.WriteString("$")
+	return regexp.MustCompile(b.String())
+}
+
+// matchPlaceholders matches s against pattern and returns the captured
+// placeholder values.
+func matchPlaceholders(pattern, s string) (map[string]string, bool) {
+	re := placeholderRegexp(pattern)
+	match := re.FindStringSubmatch(s)
+	if match == nil {
+		return nil, false
+	}
+	values := map[string]string{}
+	for i, name := range re.SubexpNames() {
+		if name != "" {
+			values[name] = match[i]
+		}
+	}
+	return values, true
+}
+
+// expandPlaceholders substitutes the placeholder values into pattern.
+func expandPlaceholders(pattern string, values map[string]string) string {
+	return placeholderPattern.ReplaceAllStringFunc(pattern, func(p string) string {
+		return values[strings.Trim(p, "{}")]
+	})
+}
+
+// Discover lists the channels whose root directory exists in fsys. A
+// directory matched by several channel layouts is reported once, under the
+// layout that Resolve would pick for it.
+func (l Layout) Discover(fsys fs.ReadDirFS) ([]Channel, error) {
+	var channels []Channel
+	seen := map[string]bool{}
+	for _, c := range l.channelLayouts() {
+		roots, err := expandRoot(fsys, strings.Split(path.Clean(c.Root), "/"), ".", map[string]string{})
+		if err != nil {
+			return nil, err
+		}
+		for _, values := range roots {
+			name := expandPlaceholders(c.Match, values)
+			if seen[name] {
+				continue
+			}
+			resolved, err := l.Resolve(name)
+			if err != nil || resolved.Root != expandPlaceholders(c.Root, values) {
+				continue
+			}
+			seen[name] = true
+			channels = append(channels, resolved)
+		}
+	}
+	return channels, nil
+}
+
+// expandRoot walks the remaining segments of a root pattern below dir and
+// returns the placeholder values of every existing directory it matches.
+func expandRoot(fsys fs.ReadDirFS, segments []string, dir string, values map[string]string) ([]map[string]string, error) {
+	if len(segments) == 0 {
+		return []map[string]string{values}, nil
+	}
+	entries, err := fsys.ReadDir(dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var found []map[string]string
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		captured, ok := matchPlaceholders(segments[0], entry.Name())
+		if !ok || !consistentValues(values, captured) {
+			continue
+		}
+		next := map[string]string{}
+		for k, v := range values {
+			next[k] = v
+		}
+		for k, v := range captured {
+			next[k] = v
+		}
+		sub, err := expandRoot(fsys, segments[1:], path.Join(dir, entry.Name()), next)
+		if err != nil {
+			return nil, err
+		}
+		found = append(found, sub...)
+	}
+	return found, nil
+}
+
+// consistentValues reports whether the newly captured placeholder values
+// agree with the ones captured from earlier segments.
+func consistentValues(values, captured map[string]string) bool {
+	for k, v := range captured {
+		if prev, ok := values[k]; ok && prev != v {
+			return false
+		}
+	}
+	return true
+}
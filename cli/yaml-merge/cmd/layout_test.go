@@ -0,0 +1,84 @@
+package cmd
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLayoutResolveDefaults(t *testing.T) {
+	var layout Layout
+
+	master, err := layout.Resolve("master")
+	assert.NoError(t, err)
+	assert.Equal(t, Channel{
+		Name:      "master",
+		Root:      "master",
+		Upstream:  "master/keycloak",
+		Patches:   "master/patches",
+		Overrides: "master/overrides",
+		Cicd:      "master/baseline",
+		Output:    "master/build",
+	}, master)
+
+	major, err := layout.Resolve("v21")
+	assert.NoError(t, err)
+	assert.Equal(t, "releases/v21/latest", major.Root)
+	assert.Equal(t, "releases/v21/latest/patches", major.Patches)
+	assert.Equal(t, "releases/v21/latest/cicd", major.Cicd)
+
+	minor, err := layout.Resolve("v20/0")
+	assert.NoError(t, err)
+	assert.Equal(t, "releases/v20/0/latest/build", minor.Output)
+
+	nightly, err := layout.Resolve("nightly")
+	assert.NoError(t, err)
+	assert.Equal(t, "releases/nightly/cicd", nightly.Cicd)
+
+	_, err = layout.Resolve("stable")
+	assert.EqualError(t, err, "No layout for channel: stable")
+}
+
+func TestLayoutFromConfig(t *testing.T) {
+	fsys := newMemFS()
+	assert.NoError(t, fsys.WriteFile(DefaultConfigFile, []byte(`
+layout:
+  channels:
+    - match: v{major}
+      root: lts/{major}
+      upstream: upstream
+      cicd: pipelines
+      output: out
+`), 0644))
+
+	cfg, err := loadConfig(fsys, DefaultConfigFile, false)
+	assert.NoError(t, err)
+
+	channel, err := cfg.Layout.Resolve("v20")
+	assert.NoError(t, err)
+	assert.Equal(t, "lts/20/upstream", channel.Upstream)
+	assert.Equal(t, "lts/20/patches", channel.Patches)
+	assert.Equal(t, "lts/20/pipelines", channel.Cicd)
+	assert.Equal(t, "lts/20/out", channel.Output)
+
+	// channels the configuration does not mention keep the default layout
+	master, err := cfg.Layout.Resolve("master")
+	assert.NoError(t, err)
+	assert.Equal(t, "master/build", master.Output)
+	assert.Equal(t, "master/baseline", master.Cicd)
+}
+
+func TestLoadConfig(t *testing.T) {
+	fsys := newMemFS()
+
+	cfg, err := loadConfig(fsys, DefaultConfigFile, false)
+	assert.NoError(t, err)
+	assert.Equal(t, defaultConfig(), cfg)
+
+	_, err = loadConfig(fsys, "custom.yaml", true)
+	assert.Error(t, err)
+
+	assert.NoError(t, fsys.WriteFile("bad.yaml", []byte("layout:\n  channels:\n    - match: v{major}\n      root: r/{minor}\n"), 0644))
+	_, err = loadConfig(fsys, "bad.yaml", true)
+	assert.EqualError(t, err, "bad.yaml: layout.channels[0]: root uses {minor} which match does not capture")
+}
@@ -0,0 +1,238 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// expressionContexts are the contexts expressions can read.
+var expressionContexts = map[string]bool{
+	"github": true, "env": true, "vars": true, "job": true, "jobs": true,
+	"steps": true, "runner": true, "secrets": true, "strategy": true,
+	"matrix": true, "needs": true, "inputs": true,
+}
+
+// expressionFunctions are the functions expressions can call, with the
+// least and most arguments they take; -1 for any number.
+var expressionFunctions = map[string][2]int{
+	"contains":   {2, 2},
+	"startswith": {2, 2},
+	"endswith":   {2, 2},
+	"format":     {1, -1},
+	"join":       {1, 2},
+	"tojson":     {1, 1},
+	"fromjson":   {1, 1},
+	"hashfiles":  {1, -1},
+	"success":    {0, 0},
+	"always":     {0, 0},
+	"cancelled":  {0, 0},
+	"failure":    {0, 0},
+}
+
+// expressionScope is what the expressions of one job, or of a composite
+// action, can refer to.
+type expressionScope struct {
+	// job is the id of the job, "" outside jobs.
+	job string
+	// needs lists the jobs the job needs; nil outside jobs.
+	needs map[string]bool
+	// steps lists the ids of the steps of the job or action; nil where
+	// there are no steps.
+	steps map[string]bool
+	// matrix lists the matrix keys of the job; nil when the job has no
+	// matrix, and unknown when it is computed by an expression.
+	matrix  map[string]bool
+	unknown bool
+}
+
+// expressionProblem is an invalid expression of a merged file.
+type expressionProblem struct {
+	// message names the YAML path, the expression and the problem.
+	message string
+	// reference tells that the expression parses but refers to needs,
+	// steps or matrix keys that do not exist. GitHub only notices these at
+	// run time, and upstream files have some.
+	reference bool
+}
+
+// lintExpressions parses every expression of a workflow or composite action
+// and checks the contexts, functions, needs, steps and matrix keys it
+// refers to.
+func lintExpressions(doc *yaml.Node) []expressionProblem {
+	root := documentRoot(doc)
+	if root == nil {
+		return nil
+	}
+	// The outputs of a composite action read the steps it runs.
+	action := expressionScope{steps: stepIDs(mappingValue(mappingValue(root, "runs"), "steps"))}
+	var problems []expressionProblem
+	lint := func(scope expressionScope, prefix string) func(at, expression string) {
+		return func(at, expression string) {
+			problems = append(problems, scope.lint(joinYAMLPath(prefix, at), expression)...)
+		}
+	}
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		key, value := root.Content[i].Value, root.Content[i+1]
+		switch key {
+		case "jobs":
+			mappingEntries(value, func(id string, job *yaml.Node) {
+				forEachExpression(job, lint(jobScope(id, job), "jobs."+id))
+			})
+		case "runs", "outputs":
+			forEachExpression(value, lint(action, key))
+		default:
+			forEachExpression(value, lint(expressionScope{}, key))
+		}
+	}
+	return problems
+}
+
+// referenceProblems returns the messages of the references of a workflow or
+// composite action to needs, steps or matrix keys that do not exist.
+func referenceProblems(doc *yaml.Node) map[string]bool {
+	messages := map[string]bool{}
+	for _, problem := range lintExpressions(doc) {
+		if problem.reference {
+			messages[problem.message] = true
+		}
+	}
+	return messages
+}
+
+// jobScope returns the scope of the expressions of a job.
+func jobScope(id string, job *yaml.Node) expressionScope {
+	scope := expressionScope{job: id, needs: map[string]bool{}, steps: stepIDs(mappingValue(job, "steps"))}
+	for _, need := range jobNeeds(job) {
+		scope.needs[need] = true
+	}
+	matrix := mappingValue(mappingValue(job, "strategy"), "matrix")
+	switch {
+	case matrix == nil:
+	case matrix.Kind != yaml.MappingNode:
+		scope.unknown = true
+	default:
+		scope.matrix = map[string]bool{}
+		mappingEntries(matrix, func(key string, value *yaml.Node) {
+			if key != "include" && key != "exclude" {
+				scope.matrix[key] = true
+				return
+			}
+			if key == "include" && value.Kind != yaml.SequenceNode {
+				scope.unknown = true
+			}
+			if key == "include" {
+				for _, entry := range value.Content {
+					for _, name := range mappingKeys(entry) {
+						scope.matrix[name] = true
+					}
+				}
+			}
+		})
+	}
+	return scope
+}
+
+// stepIDs returns the ids of a list of steps.
+func stepIDs(steps *yaml.Node) map[string]bool {
+	if steps == nil || steps.Kind != yaml.SequenceNode {
+		return nil
+	}
+	ids := map[string]bool{}
+	for _, step := range steps.Content {
+		if id := mappingValue(step, "id"); id != nil {
+			ids[id.Value] = true
+		}
+	}
+	return ids
+}
+
+// lint checks one expression at a YAML path.
+func (s expressionScope) lint(at, expression string) []expressionProblem {
+	problem := func(format string, args ...interface{}) expressionProblem {
+		return expressionProblem{message: fmt.Sprintf("%s: ${{ %s }}: %s", at, expression, fmt.Sprintf(format, args...))}
+	}
+	if strings.HasPrefix(expression, "${{") {
+		return []expressionProblem{{message: fmt.Sprintf("%s: %s: missing closing }}", at, expression)}}
+	}
+	tree, err := parseExpression(expression)
+	if err != nil {
+		return []expressionProblem{problem("syntax error: %v", err)}
+	}
+	var problems []expressionProblem
+	tree.walk(func(e *expr) {
+		switch e.kind {
+		case exprContext:
+			if !expressionContexts[strings.ToLower(e.name)] {
+				problems = append(problems, problem("undefined context %s", e.name))
+			}
+		case exprCall:
+			arity, ok := expressionFunctions[strings.ToLower(e.name)]
+			switch {
+			case !ok:
+				problems = append(problems, problem("unknown function %s", e.name))
+			case len(e.args) < arity[0] || arity[1] >= 0 && len(e.args) > arity[1]:
+				problems = append(problems, problem("%s takes %s, got %d", e.name, arguments(arity), len(e.args)))
+			}
+		case exprProperty, exprIndex:
+			if message := s.reference(e); message != "" {
+				p := problem("%s", message)
+				p.reference = true
+				problems = append(problems, p)
+			}
+		}
+	})
+	return problems
+}
+
+// reference checks a property of the needs, steps or matrix context.
+func (s expressionScope) reference(e *expr) string {
+	object := e.args[0]
+	if object.kind != exprContext {
+		return ""
+	}
+	name := e.name
+	if e.kind == exprIndex {
+		index := e.args[1]
+		if index.kind != exprLiteral || index.name != "string" {
+			return ""
+		}
+		name = index.value
+	}
+	if name == "*" {
+		return ""
+	}
+	switch strings.ToLower(object.name) {
+	case "needs":
+		if s.needs != nil && !s.needs[name] {
+			return fmt.Sprintf("job %s does not need %s", s.job, name)
+		}
+	case "steps":
+		if s.steps != nil && !s.steps[name] {
+			return fmt.Sprintf("no step has the id %s", name)
+		}
+	case "matrix":
+		switch {
+		case s.job == "" || s.unknown:
+		case s.matrix == nil:
+			return fmt.Sprintf("job %s has no matrix", s.job)
+		case !s.matrix[name]:
+			return fmt.Sprintf("the matrix of job %s has no %s", s.job, name)
+		}
+	}
+	return ""
+}
+
+// arguments describes the number of arguments a function takes.
+func arguments(arity [2]int) string {
+	switch {
+	case arity[1] < 0:
+		return fmt.Sprintf("at least %d arguments", arity[0])
+	case arity[0] == arity[1] && arity[0] == 1:
+		return "1 argument"
+	case arity[0] == arity[1]:
+		return fmt.Sprintf("%d arguments", arity[0])
+	}
+	return fmt.Sprintf("%d to %d arguments", arity[0], arity[1])
+}
@@ -0,0 +1,154 @@
+package cmd
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v3"
+)
+
+// lintMessages returns the problems lintExpressions finds in doc, marking
+// those that are references.
+func lintMessages(doc *yaml.Node) []string {
+	var messages []string
+	for _, problem := range lintExpressions(doc) {
+		if problem.reference {
+			problem.message += " (reference)"
+		}
+		messages = append(messages, problem.message)
+	}
+	return messages
+}
+
+func TestLintExpressions(t *testing.T) {
+	var doc yaml.Node
+	assert.NoError(t, yaml.Unmarshal([]byte(`on:
+    workflow_call:
+        outputs:
+            db: {value: "${{ jobs.setup.outputs.db }}"}
+concurrency: ${{ github.workflow }}-${{ gitHub.ref }}
+jobs:
+    setup:
+        if: github.event_name != 'schedule' && vars.RUN == 'true'
+        outputs:
+            db: ${{ steps.db.outputs.value }}
+        steps:
+            - id: db
+              run: echo ${{ toJSON(matrix) }}
+            - run: echo ${{ steps.missing.outputs.value }}
+    test:
+        needs: setup
+        strategy:
+            matrix:
+                db: ${{ fromJson(needs.setup.outputs.db) }}
+                include:
+                    - {db: mysql, image: mysql:8}
+        steps:
+            - run: echo ${{ matrix.db }} ${{ matrix.image }} ${{ matrix.jdk }}
+            - if: ${{ needs.build.result == 'success' || needs['setup'].result == 'success' }}
+              run: echo ${{ contains(github.ref) }} ${{ hashfiles('**/pom.xml') }} ${{ lowercase(github.ref) }}
+            - run: echo ${{ github.ref == }} ${{ secret.TOKEN }} ${{ always()
+    dynamic:
+        strategy:
+            matrix: ${{ fromJson(inputs.matrix) }}
+        steps:
+            - run: echo ${{ matrix.anything }} ${{ steps.x.outputs.y }}
+`), &doc))
+
+	assert.Equal(t, []string{
+		"jobs.setup.steps[1].run: ${{ steps.missing.outputs.value }}: no step has the id missing (reference)",
+		"jobs.test.steps[0].run: ${{ matrix.jdk }}: the matrix of job test has no jdk (reference)",
+		"jobs.test.steps[1].if: ${{ needs.build.result == 'success' || needs['setup'].result == 'success' }}: job test does not need build (reference)",
+		"jobs.test.steps[1].run: ${{ contains(github.ref) }}: contains takes 2 arguments, got 1",
+		"jobs.test.steps[1].run: ${{ lowercase(github.ref) }}: unknown function lowercase",
+		"jobs.test.steps[2].run: ${{ github.ref == }}: syntax error: column 14: unexpected end of expression",
+		"jobs.test.steps[2].run: ${{ secret.TOKEN }}: undefined context secret",
+		"jobs.test.steps[2].run: ${{ always(): missing closing }}",
+		"jobs.dynamic.steps[0].run: ${{ steps.x.outputs.y }}: no step has the id x (reference)",
+	}, lintMessages(&doc))
+}
+
+func TestLintCompositeAction(t *testing.T) {
+	var doc yaml.Node
+	assert.NoError(t, yaml.Unmarshal([]byte(`outputs:
+    version: {value: "${{ steps.version.outputs.value }}"}
+runs:
+    using: composite
+    steps:
+        - id: cache
+          uses: actions/cache@v3
+        - if: steps.cache.outputs.cache-hit != 'true' && matrix.os == 'linux'
+          run: mvn -B ${{ inputs.maven-args }}
+          shell: bash
+`), &doc))
+	assert.Equal(t, []string{
+		"outputs.version.value: ${{ steps.version.outputs.value }}: no step has the id version (reference)",
+	}, lintMessages(&doc))
+}
+
+func TestMergeFailsOnInvalidExpressions(t *testing.T) {
+	fsys := newMemFS()
+	assert.NoError(t, fsys.WriteFile("master/keycloak/.github/workflows/ci.yml", []byte(`name: CI
+jobs:
+    build:
+        steps:
+            - id: build
+              run: ./mvnw install
+`), 0644))
+	assert.NoError(t, fsys.WriteFile("master/patches/.github/workflows/ci.yml", []byte(`jobs:
+    build:
+        steps:
+            - run: echo ${{ steps.bulid.outputs.dir }}
+`), 0644))
+
+	err := newMerger(fsys, defaultConfig(), &bytes.Buffer{}).mergeVersions("master")
+	assert.ErrorContains(t, err, `Invalid expressions in "master/build/.github/workflows/ci.yml":
+  jobs.build.steps[1].run: ${{ steps.bulid.outputs.dir }}: no step has the id bulid`)
+	_, err = fsys.ReadFile("master/build/.github/workflows/ci.yml")
+	assert.Error(t, err)
+}
+
+func TestUpstreamReferencesAreWarnings(t *testing.T) {
+	fsys := newMemFS()
+	assert.NoError(t, fsys.WriteFile("master/keycloak/.github/workflows/ci.yml", []byte(`name: CI
+on: push
+jobs:
+    build:
+        runs-on: ubuntu-latest
+        steps:
+            - if: steps.cache.outputs.cache-hit != 'true'
+              run: ./mvnw install
+`), 0644))
+	assert.NoError(t, fsys.WriteFile("master/patches/.github/workflows/ci.yml", []byte("env: {A: 1}\n"), 0644))
+
+	var out bytes.Buffer
+	assert.NoError(t, newMerger(fsys, defaultConfig(), &out).mergeVersions("master"))
+	assert.Contains(t, out.String(), "master/build/.github/workflows/ci.yml: warning: jobs.build.steps[0].if: ${{ steps.cache.outputs.cache-hit != 'true' }}: no step has the id cache \n")
+	assert.True(t, fileExists(fsys, "master/build/.github/workflows/ci.yml"))
+}
+
+func TestReferencesBrokenByTransformsAreErrors(t *testing.T) {
+	fsys := newMemFS()
+	assert.NoError(t, fsys.WriteFile("master/keycloak/.github/workflows/ci.yml", []byte(`name: CI
+on: push
+jobs:
+    test:
+        runs-on: ubuntu-latest
+        strategy:
+            matrix:
+                server: [quarkus]
+                include:
+                    - {server: quarkus, java: 17}
+        steps:
+            - run: ./mvnw test -Djava=${{ matrix.java }}
+`), 0644))
+	assert.NoError(t, fsys.WriteFile("master/patches/.github/workflows/ci.yml", []byte("env: {A: 1}\n"), 0644))
+	cfg := defaultConfig()
+	assert.NoError(t, yaml.Unmarshal([]byte("[{remove-include: [{java: 17}]}]\n"), &cfg.Transforms.Matrix))
+
+	err := newMerger(fsys, cfg, &bytes.Buffer{}).mergeVersions("master")
+	assert.ErrorContains(t, err, `Invalid expressions in "master/build/.github/workflows/ci.yml":
+  jobs.test.steps[0].run: ${{ matrix.java }}: the matrix of job test has no java`)
+	assert.False(t, fileExists(fsys, "master/build/.github/workflows/ci.yml"))
+}
@@ -0,0 +1,142 @@
+package cmd
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+)
+
+// ManifestFile is the name of the manifest kept in every channel's output
+// folder. It lists the files yaml-merge generated for the channel, which are
+// the only files it will ever delete, together with everything needed to
+// tell whether they are still up to date: the upstream commit, the tool
+// version and the hashes of every input and output.
+const ManifestFile = ".yaml-merge-manifest.json"
+
+// Manifest records the outputs generated for one channel.
+type Manifest struct {
+	Channel     string           `json:"channel"`
+	ToolVersion string           `json:"toolVersion,omitempty"`
+	Upstream    ManifestUpstream `json:"upstream"`
+	Outputs     []ManifestOutput `json:"outputs"`
+}
+
+// ManifestUpstream identifies the upstream checkout the outputs were
+// generated from. Commit is empty when it could not be determined.
+type ManifestUpstream struct {
+	Path   string `json:"path"`
+	Commit string `json:"commit,omitempty"`
+}
+
+// ManifestOutput is one generated file and the inputs it was generated
+// from. Paths are relative to the workspace root; hashes are
+// "sha256:<hex>" digests of the file contents. Inputs combines everything
+// the output depends on and decides whether it has to be regenerated.
+// Actions lists the actions and workflows of other repositories the output
+// uses when the action policy is enabled.
+type ManifestOutput struct {
+	Path         string   `json:"path"`
+	Hash         string   `json:"hash,omitempty"`
+	Inputs       string   `json:"inputs,omitempty"`
+	Patch        string   `json:"patch,omitempty"`
+	PatchHash    string   `json:"patchHash,omitempty"`
+	Upstream     string   `json:"upstream,omitempty"`
+	UpstreamHash string   `json:"upstreamHash,omitempty"`
+	Actions      []string `json:"actions,omitempty"`
+}
+
+// manifestPath returns the location of the manifest of channel.
+func manifestPath(channel Channel) string {
+	return path.Join(channel.Output, ManifestFile)
+}
+
+// loadManifest reads the manifest of channel. A channel that was never
+// generated has an empty manifest.
+func loadManifest(fsys fs.ReadFileFS, channel Channel) (*Manifest, error) {
+	data, err := fsys.ReadFile(manifestPath(channel))
+	if errors.Is(err, fs.ErrNotExist) {
+		return &Manifest{Channel: channel.Name}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	manifest := &Manifest{}
+	if err := json.Unmarshal(data, manifest); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", manifestPath(channel), err)
+	}
+	return manifest, nil
+}
+
+// writeManifest stages the manifest of channel in w.
+func writeManifest(w fileWriter, channel Channel, manifest *Manifest) error {
+	manifest.Channel = channel.Name
+	sort.Slice(manifest.Outputs, func(i, j int) bool {
+		return manifest.Outputs[i].Path < manifest.Outputs[j].Path
+	})
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	return w.WriteFile(manifestPath(channel), append(data, '\n'), 0644)
+}
+
+// output returns the recorded output at name.
+func (m *Manifest) output(name string) (ManifestOutput, bool) {
+	for _, output := range m.Outputs {
+		if output.Path == name {
+			return output, true
+		}
+	}
+	return ManifestOutput{}, false
+}
+
+// orphans returns the outputs of m that are missing from current, i.e. the
+// files generated by an earlier run whose patch is gone.
+func (m *Manifest) orphans(current *Manifest) []ManifestOutput {
+	var orphans []ManifestOutput
+	for _, output := range m.Outputs {
+		if _, ok := current.output(output.Path); !ok {
+			orphans = append(orphans, output)
+		}
+	}
+	return orphans
+}
+
+// removeEmptyDirs removes dir and its parents while they are empty, stopping
+// at stop, which is kept.
+func removeEmptyDirs(fsys WritableFS, dir, stop string) {
+	for dir != stop && dir != "." && dir != "/" {
+		entries, err := fsys.ReadDir(dir)
+		if err != nil || len(entries) > 0 {
+			return
+		}
+		if err := fsys.Remove(dir); err != nil {
+			return
+		}
+		dir = path.Dir(dir)
+	}
+}
+
+// hashBytes returns the digest of data as recorded in the manifest.
+func hashBytes(data []byte) string {
+	sum := sha256.Sum256(data)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// inputsHash combines the tool version, the configuration, the upstream
+// commit and every input layer of output into one digest.
+func inputsHash(configHash, commit string, output ManifestOutput) string {
+	return hashBytes([]byte(strings.Join([]string{
+		toolVersion(),
+		configHash,
+		commit,
+		output.Upstream, output.UpstreamHash,
+		output.Patch, output.PatchHash,
+	}, "\n")))
+}
@@ -0,0 +1,133 @@
+package cmd
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func outputPaths(manifest *Manifest) []string {
+	var paths []string
+	for _, output := range manifest.Outputs {
+		paths = append(paths, output.Path)
+	}
+	return paths
+}
+
+func TestManifestRecordsInputs(t *testing.T) {
+	const commit = "0123456789abcdef0123456789abcdef01234567"
+	fsys := newMemFS()
+	assert.NoError(t, fsys.WriteFile("releases/v21/latest/keycloak/.git", []byte("gitdir: ../../../../.git/modules/releases/v21/latest/keycloak\n"), 0644))
+	assert.NoError(t, fsys.WriteFile(".git/modules/releases/v21/latest/keycloak/HEAD", []byte("ref: refs/heads/main\n"), 0644))
+	assert.NoError(t, fsys.WriteFile(".git/modules/releases/v21/latest/keycloak/packed-refs", []byte("# pack-refs with: peeled\n"+commit+" refs/heads/main\n"), 0644))
+	assert.NoError(t, fsys.WriteFile("releases/v21/latest/keycloak/.github/workflows/ci.yml", []byte("name: CI\n"), 0644))
+	assert.NoError(t, fsys.WriteFile("releases/v21/latest/patches/.github/workflows/ci.yml", []byte("env: {A: 1}\n"), 0644))
+
+	Version = "1.2.3"
+	defer func() { Version = "" }()
+	assert.NoError(t, newMerger(fsys, defaultConfig(), io.Discard).mergeVersions("v21"))
+
+	manifest, err := loadManifest(fsys, Channel{Name: "v21", Output: "releases/v21/latest/build"})
+	assert.NoError(t, err)
+	output, err := fsys.ReadFile("releases/v21/latest/build/.github/workflows/ci.yml")
+	assert.NoError(t, err)
+	assert.NotEmpty(t, manifest.Outputs[0].Inputs)
+	assert.Equal(t, &Manifest{
+		Channel:     "v21",
+		ToolVersion: "1.2.3",
+		Upstream:    ManifestUpstream{Path: "releases/v21/latest/keycloak", Commit: commit},
+		Outputs: []ManifestOutput{{
+			Path:         "releases/v21/latest/build/.github/workflows/ci.yml",
+			Hash:         hashBytes(output),
+			Inputs:       manifest.Outputs[0].Inputs,
+			Patch:        "releases/v21/latest/patches/.github/workflows/ci.yml",
+			PatchHash:    hashBytes([]byte("env: {A: 1}\n")),
+			Upstream:     "releases/v21/latest/keycloak/.github/workflows/ci.yml",
+			UpstreamHash: hashBytes([]byte("name: CI\n")),
+		}},
+	}, manifest)
+}
+
+func TestPruneOrphanedOutputs(t *testing.T) {
+	fsys := newMemFS()
+	for _, file := range []string{".github/workflows/ci.yml", ".github/actions/setup/action.yml"} {
+		assert.NoError(t, fsys.WriteFile("master/keycloak/"+file, []byte("name: upstream\n"), 0644))
+		assert.NoError(t, fsys.WriteFile("master/patches/"+file, []byte("env: {A: 1}\n"), 0644))
+	}
+	// a file yaml-merge did not generate
+	assert.NoError(t, fsys.WriteFile("master/build/.github/notes.yml", []byte("mine: true\n"), 0644))
+
+	assert.NoError(t, newMerger(fsys, defaultConfig(), io.Discard).mergeVersions("master"))
+	manifest, err := loadManifest(fsys, Channel{Name: "master", Output: "master/build"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		"master/build/.github/actions/setup/action.yml",
+		"master/build/.github/workflows/ci.yml",
+	}, outputPaths(manifest))
+
+	// the action is dropped upstream and its patch deleted: without --prune
+	// its output is kept and stays owned
+	assert.NoError(t, fsys.Remove("master/keycloak/.github/actions/setup/action.yml"))
+	assert.NoError(t, fsys.Remove("master/patches/.github/actions/setup/action.yml"))
+	var out bytes.Buffer
+	assert.NoError(t, newMerger(fsys, defaultConfig(), &out).mergeVersions("master"))
+	assert.Contains(t, out.String(), "master has 1 orphaned outputs, run with --prune to delete them")
+	assert.True(t, fileExists(fsys, "master/build/.github/actions/setup/action.yml"))
+
+	out.Reset()
+	m := newMerger(fsys, defaultConfig(), &out)
+	m.prune = true
+	assert.NoError(t, m.mergeVersions("master"))
+	assert.Contains(t, out.String(), "Pruning master/build/.github/actions/setup/action.yml")
+	assert.False(t, fileExists(fsys, "master/build/.github/actions/setup/action.yml"))
+	assert.False(t, fileExists(fsys, "master/build/.github/actions"))
+	assert.True(t, fileExists(fsys, "master/build/.github/workflows/ci.yml"))
+	assert.True(t, fileExists(fsys, "master/build/.github/notes.yml"))
+
+	manifest, err = loadManifest(fsys, Channel{Name: "master", Output: "master/build"})
+	assert.NoError(t, err)
+	assert.Len(t, manifest.Outputs, 1)
+}
+
+func TestIncrementalRebuild(t *testing.T) {
+	fsys := newMemFS()
+	for _, name := range []string{"ci", "docs", "js-ci"} {
+		file := ".github/workflows/" + name + ".yml"
+		assert.NoError(t, fsys.WriteFile("master/keycloak/"+file, []byte("name: "+name+"\n"), 0644))
+		assert.NoError(t, fsys.WriteFile("master/patches/"+file, []byte("env: {A: 1}\n"), 0644))
+	}
+	run := func(cfg *Config, force bool) string {
+		var out bytes.Buffer
+		m := newMerger(fsys, cfg, &out)
+		m.force = force
+		assert.NoError(t, m.mergeVersions("master"))
+		return out.String()
+	}
+
+	assert.Contains(t, run(defaultConfig(), false), "3 regenerated, 0 up to date")
+	assert.Contains(t, run(defaultConfig(), false), "0 regenerated, 3 up to date")
+
+	// a changed patch, a changed upstream file and a deleted output are rebuilt
+	assert.NoError(t, fsys.WriteFile("master/patches/.github/workflows/ci.yml", []byte("env: {A: 2}\n"), 0644))
+	assert.NoError(t, fsys.WriteFile("master/keycloak/.github/workflows/docs.yml", []byte("name: Docs\n"), 0644))
+	assert.NoError(t, fsys.Remove("master/build/.github/workflows/js-ci.yml"))
+	out := run(defaultConfig(), false)
+	assert.Contains(t, out, "3 regenerated, 0 up to date")
+	assert.True(t, fileExists(fsys, "master/build/.github/workflows/js-ci.yml"))
+
+	// a new override layer is an input as well
+	assert.NoError(t, fsys.WriteFile("master/overrides/.github/workflows/docs.yml", []byte("name: Ours\n"), 0644))
+	assert.Contains(t, run(defaultConfig(), false), "1 regenerated, 2 up to date")
+
+	// so are the configuration and the tool version
+	cfg := defaultConfig()
+	cfg.Layout.Channels = []ChannelLayout{{Match: "master", Root: "master"}}
+	assert.Contains(t, run(cfg, false), "3 regenerated, 0 up to date")
+	Version = "next"
+	assert.Contains(t, run(cfg, false), "3 regenerated, 0 up to date")
+	Version = ""
+
+	assert.Contains(t, run(defaultConfig(), true), "3 regenerated, 0 up to date")
+}
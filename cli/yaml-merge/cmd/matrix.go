@@ -0,0 +1,360 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// MatrixSpec changes the strategy.matrix of the jobs it matches. Axes are
+// identified by name; values and include or exclude entries are compared
+// by content, so quoting and key order do not matter.
+type MatrixSpec struct {
+	WorkflowSelector `yaml:",inline"`
+	// Jobs restricts the spec to job ids matching one of these globs; it
+	// applies to every job with a matrix when empty.
+	Jobs []string `yaml:"jobs"`
+	// Restrict keeps only the listed values of an axis. Include and exclude
+	// entries naming a removed value are removed with it.
+	Restrict map[string][]yaml.Node `yaml:"restrict"`
+	// Add appends values to an axis, creating the axis if needed.
+	Add map[string][]yaml.Node `yaml:"add"`
+	// Include and Exclude are appended to the matrix' include and exclude
+	// lists unless an equal entry is present.
+	Include []yaml.Node `yaml:"include"`
+	Exclude []yaml.Node `yaml:"exclude"`
+	// RemoveInclude and RemoveExclude remove the include and exclude
+	// entries that have every key and value of one of their entries.
+	RemoveInclude []yaml.Node `yaml:"remove-include"`
+	RemoveExclude []yaml.Node `yaml:"remove-exclude"`
+}
+
+// validate checks the globs and entries of the spec.
+func (s MatrixSpec) validate() error {
+	if err := s.WorkflowSelector.validate(); err != nil {
+		return err
+	}
+	if err := validateGlobs(s.Jobs); err != nil {
+		return fmt.Errorf("jobs: %w", err)
+	}
+	for axis, values := range s.Restrict {
+		if len(values) == 0 {
+			return fmt.Errorf("restrict %s: no values", axis)
+		}
+	}
+	for _, list := range []struct {
+		name    string
+		entries []yaml.Node
+	}{
+		{"include", s.Include},
+		{"exclude", s.Exclude},
+		{"remove-include", s.RemoveInclude},
+		{"remove-exclude", s.RemoveExclude},
+	} {
+		for i, entry := range list.entries {
+			if entry.Kind != yaml.MappingNode {
+				return fmt.Errorf("%s[%d]: entries must be mappings of axis values", list.name, i)
+			}
+		}
+	}
+	return nil
+}
+
+// matches reports whether the spec applies to a job of a workflow file of
+// channel.
+func (s MatrixSpec) matches(channel Channel, workflow, job string) bool {
+	if !s.WorkflowSelector.matches(channel, workflow) {
+		return false
+	}
+	return len(s.Jobs) == 0 || matchingGlob(s.Jobs, job) != ""
+}
+
+// matrixTransform changes the matrix of every job with the first spec
+// matching it.
+type matrixTransform []MatrixSpec
+
+// apply changes the matrices of the jobs of a workflow.
+func (specs matrixTransform) apply(ctx transformContext, doc *yaml.Node) error {
+	root := documentRoot(doc)
+	if root == nil || !ctx.isWorkflow() {
+		return nil
+	}
+	var err error
+	forEachJob(root, func(id string, job *yaml.Node) {
+		for _, spec := range specs {
+			if err == nil && spec.matches(ctx.channel, ctx.path, id) {
+				err = spec.apply(ctx, id, job)
+				return
+			}
+		}
+	})
+	return err
+}
+
+// apply runs the operations of the spec on the matrix of job.
+func (s MatrixSpec) apply(ctx transformContext, id string, job *yaml.Node) error {
+	strategy := mappingValue(job, "strategy")
+	matrix := mappingValue(strategy, "matrix")
+	if matrix == nil {
+		if len(s.Add) == 0 && len(s.Include) == 0 {
+			return nil
+		}
+		if strategy == nil {
+			strategy = &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+			setMappingValue(job, "strategy", strategy)
+		}
+		matrix = &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+		setMappingValue(strategy, "matrix", matrix)
+	}
+	if matrix.Kind != yaml.MappingNode {
+		ctx.report("cannot change the matrix of job %s: it is an expression", id)
+		return nil
+	}
+
+	for _, axis := range sortedKeys(s.Restrict) {
+		if err := restrictAxis(ctx, id, matrix, axis, s.Restrict[axis]); err != nil {
+			return err
+		}
+	}
+	for _, axis := range sortedKeys(s.Add) {
+		values := axisValues(ctx, id, matrix, axis, true)
+		if values == nil {
+			continue
+		}
+		for i := range s.Add[axis] {
+			value := &s.Add[axis][i]
+			if indexOfValue(values.Content, value) < 0 {
+				values.Content = append(values.Content, cloneNode(value))
+			}
+		}
+	}
+	removeEntries(ctx, id, matrix, "include", s.RemoveInclude)
+	removeEntries(ctx, id, matrix, "exclude", s.RemoveExclude)
+	addEntries(matrix, "include", s.Include)
+	addEntries(matrix, "exclude", s.Exclude)
+	return nil
+}
+
+// axisValues returns the value list of an axis of matrix, or nil when it
+// is missing or an expression. A missing axis is added when create is set.
+func axisValues(ctx transformContext, id string, matrix *yaml.Node, axis string, create bool) *yaml.Node {
+	if axis == "include" || axis == "exclude" {
+		ctx.report("%s of job %s is not an axis", axis, id)
+		return nil
+	}
+	values := mappingValue(matrix, axis)
+	switch {
+	case values == nil && create:
+		values = &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq"}
+		setMappingValue(matrix, axis, values)
+	case values == nil:
+		ctx.report("job %s has no matrix axis %s", id, axis)
+		return nil
+	case values.Kind != yaml.SequenceNode:
+		ctx.report("cannot change matrix axis %s of job %s: it is an expression", axis, id)
+		return nil
+	}
+	return values
+}
+
+// restrictAxis removes the values of an axis that are not listed in keep,
+// and the include and exclude entries naming them.
+func restrictAxis(ctx transformContext, id string, matrix *yaml.Node, axis string, keep []yaml.Node) error {
+	values := axisValues(ctx, id, matrix, axis, false)
+	if values == nil {
+		return nil
+	}
+	var kept, removed []*yaml.Node
+	for _, value := range values.Content {
+		if indexOfValue(nodePointers(keep), value) >= 0 {
+			kept = append(kept, value)
+		} else {
+			removed = append(removed, value)
+		}
+	}
+	for i := range keep {
+		if indexOfValue(values.Content, &keep[i]) < 0 {
+			ctx.report("matrix axis %s of job %s has no value %s", axis, id, valueString(&keep[i]))
+		}
+	}
+	if len(kept) == 0 {
+		return fmt.Errorf("restricting matrix axis %s of job %s leaves no values", axis, id)
+	}
+	if len(removed) == 0 {
+		return nil
+	}
+	values.Content = kept
+	names := make([]string, len(removed))
+	for i, value := range removed {
+		names[i] = valueString(value)
+	}
+	ctx.report("restricting matrix axis %s of job %s: removing %s", axis, id, strings.Join(names, ", "))
+
+	for _, list := range []string{"include", "exclude"} {
+		entries := mappingValue(matrix, list)
+		if entries == nil || entries.Kind != yaml.SequenceNode {
+			continue
+		}
+		remaining := entries.Content[:0]
+		for _, entry := range entries.Content {
+			if value := mappingValue(entry, axis); value != nil && indexOfValue(removed, value) >= 0 {
+				ctx.report("removing %s entry %s of job %s", list, valueString(entry), id)
+				continue
+			}
+			remaining = append(remaining, entry)
+		}
+		entries.Content = remaining
+		if len(remaining) == 0 {
+			removeMappingKey(matrix, list)
+		}
+	}
+	return nil
+}
+
+// removeEntries removes the entries of the include or exclude list of
+// matrix that match one of patterns.
+func removeEntries(ctx transformContext, id string, matrix *yaml.Node, list string, patterns []yaml.Node) {
+	if len(patterns) == 0 {
+		return
+	}
+	entries := mappingValue(matrix, list)
+	if entries == nil || entries.Kind != yaml.SequenceNode {
+		ctx.report("job %s has no matrix %s entries to remove", id, list)
+		return
+	}
+	remaining := entries.Content[:0]
+	for _, entry := range entries.Content {
+		matched := false
+		for i := range patterns {
+			if matchesEntry(entry, &patterns[i]) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			remaining = append(remaining, entry)
+		}
+	}
+	if len(remaining) == len(entries.Content) {
+		ctx.report("no matrix %s entry of job %s matches the entries to remove", list, id)
+	}
+	entries.Content = remaining
+	if len(remaining) == 0 {
+		removeMappingKey(matrix, list)
+	}
+}
+
+// addEntries appends the entries missing from the include or exclude list
+// of matrix, in block style like the entries written upstream.
+func addEntries(matrix *yaml.Node, list string, add []yaml.Node) {
+	if len(add) == 0 {
+		return
+	}
+	entries := mappingValue(matrix, list)
+	if entries == nil || entries.Kind != yaml.SequenceNode {
+		entries = &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq"}
+		setMappingValue(matrix, list, entries)
+	}
+	for i := range add {
+		if indexOfValue(entries.Content, &add[i]) < 0 {
+			entry := cloneNode(&add[i])
+			rewriteStyle(entry, 0)
+			entries.Content = append(entries.Content, entry)
+		}
+	}
+}
+
+// matchesEntry reports whether entry has every key of pattern with an
+// equal value.
+func matchesEntry(entry, pattern *yaml.Node) bool {
+	if entry.Kind != yaml.MappingNode {
+		return false
+	}
+	for i := 0; i+1 < len(pattern.Content); i += 2 {
+		value := mappingValue(entry, pattern.Content[i].Value)
+		if value == nil || !sameValue(value, pattern.Content[i+1]) {
+			return false
+		}
+	}
+	return true
+}
+
+// sameValue reports whether two matrix values are equal, ignoring the
+// style of scalars and the order of mapping keys.
+func sameValue(a, b *yaml.Node) bool {
+	if a.Kind != b.Kind {
+		return false
+	}
+	switch a.Kind {
+	case yaml.ScalarNode:
+		return a.Value == b.Value
+	case yaml.MappingNode:
+		return len(a.Content) == len(b.Content) && matchesEntry(a, b)
+	}
+	if len(a.Content) != len(b.Content) {
+		return false
+	}
+	for i := range a.Content {
+		if !sameValue(a.Content[i], b.Content[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// indexOfValue returns the index of the first of values equal to value, or
+// -1.
+func indexOfValue(values []*yaml.Node, value *yaml.Node) int {
+	for i, v := range values {
+		if sameValue(v, value) {
+			return i
+		}
+	}
+	return -1
+}
+
+// nodePointers returns pointers to the elements of nodes.
+func nodePointers(nodes []yaml.Node) []*yaml.Node {
+	pointers := make([]*yaml.Node, len(nodes))
+	for i := range nodes {
+		pointers[i] = &nodes[i]
+	}
+	return pointers
+}
+
+// cloneNode returns a deep copy of node, so configuration nodes are never
+// shared between documents.
+func cloneNode(node *yaml.Node) *yaml.Node {
+	clone := *node
+	clone.Content = make([]*yaml.Node, len(node.Content))
+	for i, child := range node.Content {
+		clone.Content[i] = cloneNode(child)
+	}
+	return &clone
+}
+
+// valueString formats a matrix value for reports, e.g. postgres or
+// {database: postgres, group: 1}.
+func valueString(node *yaml.Node) string {
+	if node.Kind == yaml.ScalarNode {
+		return node.Value
+	}
+	flow := cloneNode(node)
+	rewriteStyle(flow, yaml.FlowStyle)
+	data, err := yaml.Marshal(flow)
+	if err != nil {
+		return "?"
+	}
+	return strings.TrimSpace(string(data))
+}
+
+// rewriteStyle sets style on node and every collection below it.
+func rewriteStyle(node *yaml.Node, style yaml.Style) {
+	if node.Kind != yaml.ScalarNode {
+		node.Style = style
+	}
+	for _, child := range node.Content {
+		rewriteStyle(child, style)
+	}
+}
@@ -0,0 +1,123 @@
+package cmd
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v3"
+)
+
+func TestMatrixTransform(t *testing.T) {
+	var doc yaml.Node
+	assert.NoError(t, yaml.Unmarshal([]byte(`name: CI
+jobs:
+    build:
+        runs-on: ubuntu-latest
+    base-integration-tests:
+        strategy:
+            matrix:
+                group: [1, 2, 3, 4, 5, 6]
+                server: [undertow, quarkus, 'wildfly']
+                include:
+                    - server: wildfly
+                      java: 17
+                    - group: 7
+                      server: quarkus
+                exclude:
+                    - server: undertow
+                      group: 6
+    store-integration-tests:
+        strategy:
+            matrix:
+                db: [postgres, mysql, mariadb, mssql, oracle]
+                exclude:
+                    - db: mssql
+    dynamic:
+        strategy:
+            matrix: ${{ fromJSON(needs.build.outputs.matrix) }}
+`), &doc))
+
+	var config struct {
+		Matrix []MatrixSpec `yaml:"matrix"`
+	}
+	assert.NoError(t, yaml.Unmarshal([]byte(`matrix:
+    - match: v{major}
+      restrict: {server: [undertow]}
+    - jobs: [base-integration-tests]
+      restrict: {server: [quarkus, wildfly, legacy]}
+      remove-exclude: [{server: undertow}]
+      include: [{group: 8, server: quarkus}, {server: quarkus, group: '7'}]
+    - jobs: [store-integration-tests]
+      restrict: {db: [postgres]}
+      add: {db: ["mysql"]}
+      exclude: [{db: mysql}]
+    - jobs: [dynamic]
+      add: {group: [1]}
+`), &config))
+	for _, spec := range config.Matrix {
+		assert.NoError(t, spec.validate())
+	}
+
+	var out bytes.Buffer
+	ctx := transformContext{channel: Channel{Name: "master"}, path: "master/build/.github/workflows/ci.yml", out: &out}
+	assert.NoError(t, matrixTransform(config.Matrix).apply(ctx, &doc))
+
+	data, err := yaml.Marshal(&doc)
+	assert.NoError(t, err)
+	assert.Equal(t, `name: CI
+jobs:
+    build:
+        runs-on: ubuntu-latest
+    base-integration-tests:
+        strategy:
+            matrix:
+                group: [1, 2, 3, 4, 5, 6]
+                server: [quarkus, 'wildfly']
+                include:
+                    - server: wildfly
+                      java: 17
+                    - group: 7
+                      server: quarkus
+                    - group: 8
+                      server: quarkus
+    store-integration-tests:
+        strategy:
+            matrix:
+                db: [postgres, "mysql"]
+                exclude:
+                    - db: mysql
+    dynamic:
+        strategy:
+            matrix: ${{ fromJSON(needs.build.outputs.matrix) }}
+`, string(data))
+	assert.Equal(t, ""+
+		"master/build/.github/workflows/ci.yml: matrix axis server of job base-integration-tests has no value legacy \n"+
+		"master/build/.github/workflows/ci.yml: restricting matrix axis server of job base-integration-tests: removing undertow \n"+
+		"master/build/.github/workflows/ci.yml: removing exclude entry {server: undertow, group: 6} of job base-integration-tests \n"+
+		"master/build/.github/workflows/ci.yml: job base-integration-tests has no matrix exclude entries to remove \n"+
+		"master/build/.github/workflows/ci.yml: restricting matrix axis db of job store-integration-tests: removing mysql, mariadb, mssql, oracle \n"+
+		"master/build/.github/workflows/ci.yml: removing exclude entry {db: mssql} of job store-integration-tests \n"+
+		"master/build/.github/workflows/ci.yml: cannot change the matrix of job dynamic: it is an expression \n",
+		out.String())
+}
+
+func TestMatrixRestrictLeavingNoValues(t *testing.T) {
+	var doc yaml.Node
+	assert.NoError(t, yaml.Unmarshal([]byte(`jobs:
+    tests:
+        strategy:
+            matrix:
+                db: [postgres]
+`), &doc))
+	ctx := transformContext{channel: Channel{Name: "master"}, path: "master/build/.github/workflows/ci.yml", out: &bytes.Buffer{}}
+	spec := MatrixSpec{Restrict: map[string][]yaml.Node{"db": {*stringNode("oracle")}}}
+	assert.EqualError(t, matrixTransform{spec}.apply(ctx, &doc), "restricting matrix axis db of job tests leaves no values")
+}
+
+func TestMatrixSpecValidation(t *testing.T) {
+	assert.NoError(t, MatrixSpec{Jobs: []string{"*-tests"}}.validate())
+	assert.Error(t, MatrixSpec{Jobs: []string{"["}}.validate())
+	assert.Error(t, MatrixSpec{Restrict: map[string][]yaml.Node{"db": nil}}.validate())
+	assert.Error(t, MatrixSpec{Include: []yaml.Node{*stringNode("postgres")}}.validate())
+}
@@ -0,0 +1,180 @@
+package cmd
+
+import (
+	"errors"
+	"io/fs"
+	"path"
+	"strings"
+	"sync"
+	"testing/fstest"
+	"time"
+)
+
+// memFS is an in-memory WritableFS for tests. It is safe for concurrent
+// use.
+type memFS struct {
+	mu    sync.RWMutex
+	files fstest.MapFS
+}
+
+// newMemFS returns an empty in-memory WritableFS.
+func newMemFS() *memFS {
+	return &memFS{files: fstest.MapFS{}}
+}
+
+func (m *memFS) Open(name string) (fs.File, error) {
+	name, err := cleanName("open", name)
+	if err != nil {
+		return nil, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.files.Open(name)
+}
+
+func (m *memFS) ReadFile(name string) ([]byte, error) {
+	name, err := cleanName("read", name)
+	if err != nil {
+		return nil, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.files.ReadFile(name)
+}
+
+func (m *memFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	name, err := cleanName("readdir", name)
+	if err != nil {
+		return nil, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.files.ReadDir(name)
+}
+
+func (m *memFS) Stat(name string) (fs.FileInfo, error) {
+	name, err := cleanName("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.files.Stat(name)
+}
+
+func (m *memFS) MkdirAll(name string, perm fs.FileMode) error {
+	name, err := cleanName("mkdir", name)
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.mkdirAll(name, perm)
+}
+
+// mkdirAll creates name and its parents; the caller holds m.mu.
+func (m *memFS) mkdirAll(name string, perm fs.FileMode) error {
+	for dir := name; dir != "."; dir = path.Dir(dir) {
+		if f, ok := m.files[dir]; ok {
+			if !f.Mode.IsDir() {
+				return &fs.PathError{Op: "mkdir", Path: dir, Err: errors.New("not a directory")}
+			}
+			continue
+		}
+		m.files[dir] = &fstest.MapFile{Mode: fs.ModeDir | perm.Perm(), ModTime: time.Now()}
+	}
+	return nil
+}
+
+func (m *memFS) WriteFile(name string, data []byte, perm fs.FileMode) error {
+	name, err := cleanName("write", name)
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.mkdirAll(path.Dir(name), 0755); err != nil {
+		return err
+	}
+	if f, ok := m.files[name]; ok && f.Mode.IsDir() {
+		return &fs.PathError{Op: "write", Path: name, Err: errors.New("is a directory")}
+	}
+	m.files[name] = &fstest.MapFile{Data: append([]byte(nil), data...), Mode: perm.Perm(), ModTime: time.Now()}
+	return nil
+}
+
+func (m *memFS) AppendFile(name string, data []byte, perm fs.FileMode) error {
+	name, err := cleanName("append", name)
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.mkdirAll(path.Dir(name), 0755); err != nil {
+		return err
+	}
+	var existing []byte
+	if f, ok := m.files[name]; ok {
+		existing = f.Data
+		perm = f.Mode
+	}
+	content := make([]byte, 0, len(existing)+len(data))
+	content = append(append(content, existing...), data...)
+	m.files[name] = &fstest.MapFile{Data: content, Mode: perm.Perm(), ModTime: time.Now()}
+	return nil
+}
+
+func (m *memFS) Rename(oldname, newname string) error {
+	oldname, err := cleanName("rename", oldname)
+	if err != nil {
+		return err
+	}
+	newname, err = cleanName("rename", newname)
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	f, ok := m.files[oldname]
+	if !ok {
+		return &fs.PathError{Op: "rename", Path: oldname, Err: fs.ErrNotExist}
+	}
+	if err := m.mkdirAll(path.Dir(newname), 0755); err != nil {
+		return err
+	}
+	delete(m.files, oldname)
+	m.files[newname] = f
+	if f.Mode.IsDir() {
+		prefix := oldname + "/"
+		for name, child := range m.files {
+			if strings.HasPrefix(name, prefix) {
+				delete(m.files, name)
+				m.files[newname+"/"+strings.TrimPrefix(name, prefix)] = child
+			}
+		}
+	}
+	return nil
+}
+
+func (m *memFS) Remove(name string) error {
+	name, err := cleanName("remove", name)
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	f, ok := m.files[name]
+	if !ok {
+		return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrNotExist}
+	}
+	if f.Mode.IsDir() {
+		prefix := name + "/"
+		for other := range m.files {
+			if strings.HasPrefix(other, prefix) {
+				return &fs.PathError{Op: "remove", Path: name, Err: errors.New("directory not empty")}
+			}
+		}
+	}
+	delete(m.files, name)
+	return nil
+}
@@ -0,0 +1,197 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v3"
+)
+
+// linearMerge is recursiveMerge without the mapping index: a nested scan of
+// "into" for every key of "from". It is kept as the baseline of the
+// benchmarks below.
+func linearMerge(from, into *yaml.Node) error {
+	if from.Kind != into.Kind {
+		return errors.New("cannot merge nodes of different kinds")
+	}
+	switch from.Kind {
+	case yaml.MappingNode:
+		for i := 0; i < len(from.Content); i += 2 {
+			found := false
+			for j := 0; j < len(into.Content); j += 2 {
+				if from.Content[i].Value == into.Content[j].Value {
+					found = true
+					if from.Content[i+1].Kind == yaml.ScalarNode && into.Content[j+1].Kind == yaml.ScalarNode {
+						into.Content[j+1] = from.Content[i+1]
+						break
+					}
+					if err := linearMerge(from.Content[i+1], into.Content[j+1]); err != nil {
+						return err
+					}
+					break
+				}
+			}
+			if !found {
+				into.Content = append(into.Content, from.Content[i:i+2]...)
+			}
+		}
+	case yaml.SequenceNode:
+		into.Content = append(into.Content, from.Content...)
+	case yaml.DocumentNode:
+		return linearMerge(from.Content[0], into.Content[0])
+	default:
+		return errors.New("can only merge mapping and sequence nodes")
+	}
+	return nil
+}
+
+// keycloakWorkflow generates a workflow shaped like upstream ci.yml with the
+// given number of jobs, and a patch touching every job.
+func keycloakWorkflow(jobs int) (upstream, patch string) {
+	var u, p strings.Builder
+	u.WriteString("name: Keycloak CI\non:\n  push:\n    branches-ignore: [main]\n  pull_request: {}\n  workflow_dispatch:\n")
+	u.WriteString("env:\n  DEFAULT_JDK_VERSION: 17\n  DEFAULT_JDK_DIST: temurin\n")
+	u.WriteString("concurrency:\n  group: ci-${{ github.head_ref || github.run_id }}\n  cancel-in-progress: true\njobs:\n")
+	p.WriteString("on:\n  workflow_call:\n    inputs:\n      config-path:\n        required: true\n        type: string\n")
+	p.WriteString("defaults:\n  run:\n    working-directory: ./master/keycloak\njobs:\n")
+	for i := 0; i < jobs; i++ {
+		fmt.Fprintf(&u, "  job-%d:\n    name: Job %d\n    runs-on: ubuntu-latest\n    needs: build\n    timeout-minutes: 45\n", i, i)
+		fmt.Fprintf(&u, "    strategy:\n      matrix:\n        server: [quarkus, undertow-map]\n        tests: [group1, group2, group3]\n      fail-fast: false\n")
+		fmt.Fprintf(&u, "    steps:\n      - uses: actions/checkout@v3\n      - id: setup\n        uses: ./.github/actions/integration-test-setup\n")
+		fmt.Fprintf(&u, "      - name: Run tests\n        run: ./mvnw test -nsu -B -f testsuite/pom.xml -Dtest=Job%dTest\n", i)
+		fmt.Fprintf(&p, "  job-%d:\n    env:\n      JOB: job-%d\n    steps:\n      - name: Upload reports\n        uses: ./.github/actions/upload-surefire-reports\n", i, i)
+	}
+	return u.String(), p.String()
+}
+
+// realmExport generates a realm export with the given number of clients. Its
+// large mappings (client roles keyed by client id, attributes, localization
+// texts) are what a patch typically overrides key by key.
+func realmExport(clients int) (upstream, patch string) {
+	var u, p strings.Builder
+	u.WriteString("id: bench\nrealm: bench\nenabled: true\nsslRequired: external\nattributes:\n")
+	for i := 0; i < clients; i++ {
+		fmt.Fprintf(&u, "  attribute.%d: \"value-%d\"\n", i, i)
+	}
+	u.WriteString("roles:\n  realm:\n    - name: offline_access\n      composite: false\n  client:\n")
+	for i := 0; i < clients; i++ {
+		fmt.Fprintf(&u, "    client-%d:\n      - name: manage-%d\n        composite: false\n        clientRole: true\n", i, i)
+	}
+	u.WriteString("localizationTexts:\n  en:\n")
+	for i := 0; i < clients; i++ {
+		fmt.Fprintf(&u, "    text.%d: \"Text %d\"\n", i, i)
+	}
+	p.WriteString("attributes:\n")
+	for i := 0; i < clients; i += 2 {
+		fmt.Fprintf(&p, "  attribute.patched.%d: \"patched-%d\"\n", clients-1-i, i)
+	}
+	p.WriteString("roles:\n  client:\n")
+	for i := 0; i < clients; i += 2 {
+		fmt.Fprintf(&p, "    client-%d:\n      - name: view-%d\n", clients-1-i, i)
+	}
+	p.WriteString("localizationTexts:\n  en:\n")
+	for i := 0; i < clients; i += 2 {
+		fmt.Fprintf(&p, "    text.new.%d: \"New %d\"\n", i, i)
+	}
+	return u.String(), p.String()
+}
+
+// flatMapping generates a mapping with the given number of keys, and a
+// patch setting the second half of them and as many new ones.
+func flatMapping(keys int) (upstream, patch string) {
+	var u, p strings.Builder
+	for i := 0; i < keys; i++ {
+		fmt.Fprintf(&u, "key-%d: {name: upstream}\n", i)
+	}
+	for i := keys / 2; i < keys+keys/2; i++ {
+		fmt.Fprintf(&p, "key-%d: {name: patch}\n", i)
+	}
+	return u.String(), p.String()
+}
+
+func mustParse(tb testing.TB, data string) *yaml.Node {
+	var node yaml.Node
+	if err := yaml.Unmarshal([]byte(data), &node); err != nil {
+		tb.Fatal(err)
+	}
+	return &node
+}
+
+func TestIndexedMergeMatchesLinearMerge(t *testing.T) {
+	for name, gen := range map[string]func(int) (string, string){
+		"workflow":     keycloakWorkflow,
+		"realm-export": realmExport,
+		"flat":         flatMapping,
+	} {
+		// large enough for the jobs and clients to be indexed
+		upstream, patch := gen(2 * mappingIndexThreshold)
+		indexed, linear := mustParse(t, upstream), mustParse(t, upstream)
+		assert.NoError(t, recursiveMerge(mustParse(t, patch), indexed), name)
+		assert.NoError(t, linearMerge(mustParse(t, patch), linear), name)
+
+		indexedOut, err := yaml.Marshal(indexed)
+		assert.NoError(t, err)
+		linearOut, err := yaml.Marshal(linear)
+		assert.NoError(t, err)
+		assert.Equal(t, string(linearOut), string(indexedOut), name)
+	}
+}
+
+func TestRecursiveMergeDuplicateKeys(t *testing.T) {
+	into := mustParse(t, "a: {x: 1}\nb: 2\n")
+	from := mustParse(t, "c: {y: 1}\nc: {z: 2}\na: {w: 3}\n")
+	assert.NoError(t, recursiveMerge(from, into))
+	out, err := yaml.Marshal(into)
+	assert.NoError(t, err)
+	assert.Equal(t, "a: {x: 1, w: 3}\nb: 2\nc: {y: 1, z: 2}\n", string(out))
+}
+
+func TestRecursiveMergeReplacesScalars(t *testing.T) {
+	into := mustParse(t, "on:\n  push: {}\n  workflow_dispatch:\nname: Upstream\n")
+	from := mustParse(t, "on:\n  workflow_dispatch:\n  workflow_call: ~\nname: Patched\n")
+	assert.NoError(t, recursiveMerge(from, into))
+	out, err := yaml.Marshal(into)
+	assert.NoError(t, err)
+	assert.Equal(t, "on:\n    push: {}\n    workflow_dispatch:\n    workflow_call: ~\nname: Patched\n", string(out))
+
+	assert.EqualError(t, recursiveMerge(mustParse(t, "on: push\n"), mustParse(t, "on: {push: {}}\n")), "at key on: cannot merge nodes of different kinds")
+}
+
+func benchmarkMerge(b *testing.B, merge func(from, into *yaml.Node) error, upstream, patch string) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		into, from := mustParse(b, upstream), mustParse(b, patch)
+		b.StartTimer()
+		if err := merge(from, into); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkMerge(b *testing.B) {
+	inputs := []struct {
+		name string
+		gen  func(int) (string, string)
+		size int
+	}{
+		{"flat/keys=32", flatMapping, 32},
+		{"flat/keys=64", flatMapping, 64},
+		{"workflow/jobs=40", keycloakWorkflow, 40},
+		{"workflow/jobs=400", keycloakWorkflow, 400},
+		{"realm-export/clients=500", realmExport, 500},
+		{"realm-export/clients=5000", realmExport, 5000},
+	}
+	for _, input := range inputs {
+		upstream, patch := input.gen(input.size)
+		b.Run(input.name+"/indexed", func(b *testing.B) {
+			benchmarkMerge(b, recursiveMerge, upstream, patch)
+		})
+		b.Run(input.name+"/linear", func(b *testing.B) {
+			benchmarkMerge(b, linearMerge, upstream, patch)
+		})
+	}
+}
@@ -0,0 +1,142 @@
+package cmd
+
+import (
+	"errors"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// NamespaceTransform adds the version to the identifiers that have to be
+// unique across the workflows of all versions: the workflow name, the
+// concurrency groups, artifact names and cache keys. Every version is
+// rewritten the same way, so an artifact uploaded by one workflow or action
+// is still found by the download in another. Job ids are left alone, so
+// needs keep pointing at the same jobs.
+type NamespaceTransform struct {
+	// Position is "prefix" (the default) or "suffix". Cache keys are always
+	// prefixed, as restore-keys match on key prefixes.
+	Position string `yaml:"position"`
+	// Separator goes between the version and the identifier, "-" by default.
+	Separator string `yaml:"separator"`
+}
+
+// validate checks the position.
+func (t *NamespaceTransform) validate() error {
+	switch t.Position {
+	case "", "prefix", "suffix":
+		return nil
+	}
+	return errors.New("position must be prefix or suffix")
+}
+
+// namespacer adds one version to identifiers.
+type namespacer struct {
+	version   string
+	separator string
+	suffix    bool
+}
+
+func (t *NamespaceTransform) namespacer(channel Channel) namespacer {
+	separator := t.Separator
+	if separator == "" {
+		separator = "-"
+	}
+	return namespacer{version: channelSelector(channel), separator: separator, suffix: t.Position == "suffix"}
+}
+
+// identifier returns s with the version added, leaving identifiers that
+// already carry it alone.
+func (n namespacer) identifier(s string) string {
+	if n.suffix {
+		return n.suffixed(s)
+	}
+	return n.prefixed(s)
+}
+
+func (n namespacer) prefixed(s string) string {
+	if strings.HasPrefix(s, n.version+n.separator) {
+		return s
+	}
+	return n.version + n.separator + s
+}
+
+func (n namespacer) suffixed(s string) string {
+	if strings.HasSuffix(s, n.separator+n.version) {
+		return s
+	}
+	return s + n.separator + n.version
+}
+
+// scalar rewrites a scalar node with fn, ignoring other kinds of nodes.
+func (n namespacer) scalar(node *yaml.Node, fn func(string) string) {
+	if node != nil && node.Kind == yaml.ScalarNode && node.Value != "" {
+		node.Value = fn(node.Value)
+	}
+}
+
+// concurrency namespaces a concurrency setting, given either as the group
+// itself or as a mapping with a group.
+func (n namespacer) concurrency(node *yaml.Node) {
+	if node == nil {
+		return
+	}
+	if node.Kind == yaml.MappingNode {
+		node = mappingValue(node, "group")
+	}
+	n.scalar(node, n.identifier)
+}
+
+// apply namespaces a workflow or action with the version of its channel.
+func (t *NamespaceTransform) apply(ctx transformContext, doc *yaml.Node) error {
+	root := documentRoot(doc)
+	if root == nil {
+		return nil
+	}
+	n := t.namespacer(ctx.channel)
+	if ctx.isWorkflow() {
+		n.scalar(mappingValue(root, "name"), n.identifier)
+		n.concurrency(mappingValue(root, "concurrency"))
+		forEachJob(root, func(_ string, job *yaml.Node) {
+			n.concurrency(mappingValue(job, "concurrency"))
+		})
+	}
+	forEachStep(root, func(step *yaml.Node) {
+		switch stepAction(step) {
+		case "actions/upload-artifact":
+			with := mappingValue(step, "with")
+			if with == nil {
+				with = &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+				setMappingValue(step, "with", with)
+			}
+			if mappingValue(with, "name") == nil {
+				// the action's default name would collide as well
+				setMappingValue(with, "name", stringNode("artifact"))
+			}
+			n.scalar(mappingValue(with, "name"), n.identifier)
+		case "actions/download-artifact":
+			name := mappingValue(mappingValue(step, "with"), "name")
+			if name == nil {
+				ctx.report("a download-artifact step without a name downloads the artifacts of every version")
+				return
+			}
+			n.scalar(name, n.identifier)
+		case "actions/cache", "actions/cache/restore", "actions/cache/save":
+			with := mappingValue(step, "with")
+			n.scalar(mappingValue(with, "key"), n.prefixed)
+			n.scalar(mappingValue(with, "restore-keys"), n.restoreKeys)
+		}
+	})
+	return nil
+}
+
+// restoreKeys prefixes every line of a restore-keys list.
+func (n namespacer) restoreKeys(keys string) string {
+	lines := strings.Split(keys, "\n")
+	for i, line := range lines {
+		if trimmed := strings.TrimSpace(line); trimmed != "" {
+			lines[i] = strings.Replace(line, trimmed, n.prefixed(trimmed), 1)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,102 @@
+package cmd
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNamespaceTransform(t *testing.T) {
+	fsys := newMemFS()
+	assert.NoError(t, fsys.WriteFile("releases/v21/latest/keycloak/.github/workflows/ci.yml", []byte(`name: Keycloak CI
+concurrency:
+    group: ci-${{ github.ref }}
+jobs:
+    build:
+        concurrency: build
+        steps:
+            - uses: actions/cache@v3
+              with:
+                key: maven-${{ hashFiles('**/pom.xml') }}
+                restore-keys: |
+                    maven-
+            - uses: actions/upload-artifact@v3
+              with:
+                name: keycloak-artifacts.zip
+            - uses: actions/upload-artifact@v3
+    test:
+        needs: build
+        steps:
+            - uses: actions/download-artifact@v3
+              with:
+                name: keycloak-artifacts.zip
+            - uses: actions/download-artifact@v3
+`), 0644))
+	assert.NoError(t, fsys.WriteFile("releases/v21/latest/keycloak/.github/actions/build/action.yml", []byte(`name: Build
+runs:
+    using: composite
+    steps:
+        - uses: actions/upload-artifact@v3
+          with:
+            name: m2-keycloak.tzts
+`), 0644))
+	assert.NoError(t, fsys.WriteFile("releases/v21/latest/patches/.github/workflows/ci.yml", []byte("env: {A: 1}\n"), 0644))
+	assert.NoError(t, fsys.WriteFile("releases/v21/latest/patches/.github/actions/build/action.yml", []byte("description: build\n"), 0644))
+	assert.NoError(t, fsys.WriteFile(DefaultConfigFile, []byte("transforms:\n    namespace: {}\n"), 0644))
+
+	cfg, err := loadConfig(fsys, DefaultConfigFile, true)
+	assert.NoError(t, err)
+	var out bytes.Buffer
+	assert.NoError(t, newMerger(fsys, cfg, &out).mergeVersions("v21"))
+	assert.Contains(t, out.String(), "releases/v21/latest/build/.github/workflows/ci.yml: a download-artifact step without a name downloads the artifacts of every version")
+
+	data, err := fsys.ReadFile("releases/v21/latest/build/.github/workflows/ci.yml")
+	assert.NoError(t, err)
+	assert.Equal(t, `name: v21-Keycloak CI
+concurrency:
+    group: v21-ci-${{ github.ref }}
+jobs:
+    build:
+        concurrency: v21-build
+        steps:
+            - uses: actions/cache@v3
+              with:
+                key: v21-maven-${{ hashFiles('**/pom.xml') }}
+                restore-keys: |
+                    v21-maven-
+            - uses: actions/upload-artifact@v3
+              with:
+                name: v21-keycloak-artifacts.zip
+            - uses: actions/upload-artifact@v3
+              with:
+                name: v21-artifact
+    test:
+        needs: build
+        steps:
+            - uses: actions/download-artifact@v3
+              with:
+                name: v21-keycloak-artifacts.zip
+            - uses: actions/download-artifact@v3
+env: {A: 1}
+`, generatedBody(t, data))
+
+	data, err = fsys.ReadFile("releases/v21/latest/build/.github/actions/build/action.yml")
+	assert.NoError(t, err)
+	assert.Contains(t, generatedBody(t, data), "name: Build\n")
+	assert.Contains(t, generatedBody(t, data), "name: v21-m2-keycloak.tzts\n")
+}
+
+func TestNamespacer(t *testing.T) {
+	channel := Channel{Name: "v20/0", Major: "20", Minor: "0"}
+	prefix := (&NamespaceTransform{}).namespacer(channel)
+	assert.Equal(t, "v20.0-ci", prefix.identifier("ci"))
+	assert.Equal(t, "v20.0-ci", prefix.identifier("v20.0-ci"))
+
+	suffix := (&NamespaceTransform{Position: "suffix", Separator: " "}).namespacer(channel)
+	assert.Equal(t, "Keycloak CI v20.0", suffix.identifier("Keycloak CI"))
+	assert.Equal(t, "Keycloak CI v20.0", suffix.identifier("Keycloak CI v20.0"))
+	assert.Equal(t, "v20.0 a-\nv20.0 b-\n", suffix.restoreKeys("a-\nb-\n"))
+
+	assert.EqualError(t, (&NamespaceTransform{Position: "middle"}).validate(), "position must be prefix or suffix")
+}
@@ -0,0 +1,89 @@
+package cmd
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"runtime"
+	"sync"
+)
+
+// task is one unit of work of a parallel run. It writes its progress to out
+// instead of the shared output so that runs stay readable.
+type task func(out io.Writer) error
+
+// runParallel runs tasks on up to workers goroutines (all CPUs when workers
+// is not positive). The output of every task is buffered and copied to out
+// in task order as soon as all earlier tasks have finished, so the printed
+// log is the same as for a sequential run. The errors of all tasks are
+// joined in task order.
+func runParallel(out io.Writer, workers int, tasks []task) error {
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	if workers > len(tasks) {
+		workers = len(tasks)
+	}
+
+	buffers := make([]bytes.Buffer, len(tasks))
+	errs := make([]error, len(tasks))
+	done := make([]chan struct{}, len(tasks))
+	for i := range done {
+		done[i] = make(chan struct{})
+	}
+
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				errs[i] = runTask(tasks[i], &buffers[i])
+				close(done[i])
+			}
+		}()
+	}
+	go func() {
+		for i := range tasks {
+			indexes <- i
+		}
+		close(indexes)
+	}()
+
+	var writeErr error
+	for i := range tasks {
+		<-done[i]
+		if _, err := buffers[i].WriteTo(out); err != nil && writeErr == nil {
+			writeErr = err
+		}
+	}
+	wg.Wait()
+	return errors.Join(append(errs, writeErr)...)
+}
+
+// runTask runs t, turning a panic into an error so that one bad input does
+// not take down the other workers.
+func runTask(t task, out io.Writer) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = panicError{value: r}
+		}
+	}()
+	return t(out)
+}
+
+// panicError wraps the value a task panicked with.
+type panicError struct {
+	value interface{}
+}
+
+func (p panicError) Error() string {
+	if err, ok := p.value.(error); ok {
+		return "panic: " + err.Error()
+	}
+	if s, ok := p.value.(string); ok {
+		return "panic: " + s
+	}
+	return "panic during merge"
+}
@@ -0,0 +1,52 @@
+package cmd
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRunParallelKeepsTaskOrder(t *testing.T) {
+	var tasks []task
+	for i := 0; i < 20; i++ {
+		i := i
+		tasks = append(tasks, func(out io.Writer) error {
+			// later tasks finish first
+			time.Sleep(time.Duration(20-i) * time.Millisecond)
+			fmt.Fprintf(out, "task %d\n", i)
+			return nil
+		})
+	}
+
+	var out bytes.Buffer
+	assert.NoError(t, runParallel(&out, 8, tasks))
+
+	var expected bytes.Buffer
+	for i := 0; i < 20; i++ {
+		fmt.Fprintf(&expected, "task %d\n", i)
+	}
+	assert.Equal(t, expected.String(), out.String())
+}
+
+func TestRunParallelJoinsErrors(t *testing.T) {
+	first := errors.New("first")
+	second := errors.New("second")
+	ran := make(chan int, 4)
+	tasks := []task{
+		func(io.Writer) error { ran <- 0; return first },
+		func(io.Writer) error { ran <- 1; panic("boom") },
+		func(io.Writer) error { ran <- 2; return nil },
+		func(io.Writer) error { ran <- 3; return second },
+	}
+
+	err := runParallel(io.Discard, 2, tasks)
+	assert.ErrorIs(t, err, first)
+	assert.ErrorIs(t, err, second)
+	assert.EqualError(t, err, "first\npanic: boom\nsecond")
+	assert.Len(t, ran, 4)
+}
@@ -0,0 +1,297 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultPermissionsFile is the permissions policy file read when the
+// permissions transform names none.
+const DefaultPermissionsFile = "permissions-policy.yaml"
+
+// PermissionsTransform gives the GITHUB_TOKEN of the workflows it rewrites
+// the least privileges they need. Workflows without permissions get the
+// read-only Default; jobs without permissions get the scopes the actions
+// of their steps need on top of it. Permissions set upstream are kept.
+type PermissionsTransform struct {
+	// File is the permissions policy file, holding actions and jobs like
+	// this section, read from the workspace root; DefaultPermissionsFile by
+	// default. The configuration overrides it.
+	File string `yaml:"file"`
+	// Default is the top-level permissions, contents: read when empty.
+	Default map[string]string `yaml:"default"`
+	// Actions maps globs of uses references, matched with and without their
+	// ref, to the scopes the steps using them need. They replace the scopes
+	// yaml-merge knows for the action; local actions are only granted scopes
+	// through them.
+	Actions map[string]map[string]string `yaml:"actions"`
+	// Jobs sets the permissions of jobs outright; the first entry matching a
+	// job wins.
+	Jobs []JobPermissions `yaml:"jobs"`
+}
+
+// JobPermissions sets the permissions of the jobs it matches.
+type JobPermissions struct {
+	WorkflowSelector `yaml:",inline"`
+	// Jobs lists globs of the job ids the entry applies to.
+	Jobs []string `yaml:"jobs"`
+	// Permissions are the permissions of the jobs.
+	Permissions map[string]string `yaml:"permissions"`
+}
+
+// knownPermissions are the scopes common actions need. Actions that need
+// no token are listed with no scopes; steps using an action missing here
+// are reported, as their needs are unknown.
+var knownPermissions = map[string]map[string]string{
+	"actions/cache":                            {},
+	"actions/cache/restore":                    {},
+	"actions/cache/save":                       {},
+	"actions/checkout":                         {"contents": "read"},
+	"actions/create-release":                   {"contents": "write"},
+	"actions/download-artifact":                {},
+	"actions/labeler":                          {"contents": "read", "pull-requests": "write"},
+	"actions/setup-go":                         {},
+	"actions/setup-java":                       {},
+	"actions/setup-node":                       {},
+	"actions/setup-python":                     {},
+	"actions/stale":                            {"issues": "write", "pull-requests": "write"},
+	"actions/upload-artifact":                  {},
+	"docker/setup-buildx-action":               {},
+	"docker/setup-qemu-action":                 {},
+	"dorny/paths-filter":                       {"pull-requests": "read"},
+	"dorny/test-reporter":                      {"checks": "write"},
+	"EnricoMi/publish-unit-test-result-action": {"checks": "write", "pull-requests": "write"},
+	"github/codeql-action/analyze":             {"actions": "read", "security-events": "write"},
+	"github/codeql-action/init":                {},
+	"github/codeql-action/upload-sarif":        {"security-events": "write"},
+	"marocchino/sticky-pull-request-comment":   {"pull-requests": "write"},
+	"mikepenz/action-junit-report":             {"checks": "write"},
+	"peter-evans/create-or-update-comment":     {"issues": "write", "pull-requests": "write"},
+	"peter-evans/create-pull-request":          {"contents": "write", "pull-requests": "write"},
+	"runforesight/foresight-test-kit-action":   {},
+	"softprops/action-gh-release":              {"contents": "write"},
+	"webfactory/ssh-agent":                     {},
+}
+
+// permissionScopes are the scopes of the GITHUB_TOKEN.
+var permissionScopes = map[string]bool{
+	"actions": true, "attestations": true, "checks": true, "contents": true,
+	"deployments": true, "discussions": true, "id-token": true, "issues": true,
+	"packages": true, "pages": true, "pull-requests": true,
+	"repository-projects": true, "security-events": true, "statuses": true,
+}
+
+// permissionLevels orders the access levels of a scope.
+var permissionLevels = map[string]int{"none": 0, "read": 1, "write": 2}
+
+// validatePermissions checks the scopes and levels of permissions.
+func validatePermissions(permissions map[string]string) error {
+	for _, scope := range sortedKeys(permissions) {
+		if !permissionScopes[scope] {
+			return fmt.Errorf("unknown permission %s", scope)
+		}
+		if _, ok := permissionLevels[permissions[scope]]; !ok {
+			return fmt.Errorf("permission %s: level must be read, write or none", scope)
+		}
+	}
+	return nil
+}
+
+// load adds the actions and jobs of the permissions policy file to t. A
+// missing file is only an error when it was named in the configuration.
+func (t *PermissionsTransform) load(fsys fs.ReadFileFS) error {
+	name := t.File
+	if name == "" {
+		name = DefaultPermissionsFile
+	}
+	data, err := fsys.ReadFile(name)
+	if errors.Is(err, fs.ErrNotExist) && t.File == "" {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var file PermissionsTransform
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return fmt.Errorf("parsing %s: %w", name, err)
+	}
+	if t.Actions == nil {
+		t.Actions = map[string]map[string]string{}
+	}
+	for glob, scopes := range file.Actions {
+		if _, ok := t.Actions[glob]; !ok {
+			t.Actions[glob] = scopes
+		}
+	}
+	t.Jobs = append(t.Jobs, file.Jobs...)
+	return nil
+}
+
+// validate checks the globs, scopes and levels of the transform.
+func (t *PermissionsTransform) validate() error {
+	if err := validatePermissions(t.Default); err != nil {
+		return fmt.Errorf("default: %w", err)
+	}
+	for _, glob := range sortedKeys(t.Actions) {
+		if _, err := path.Match(glob, ""); err != nil {
+			return fmt.Errorf("actions %q: %w", glob, err)
+		}
+		if err := validatePermissions(t.Actions[glob]); err != nil {
+			return fmt.Errorf("actions %q: %w", glob, err)
+		}
+	}
+	for i, job := range t.Jobs {
+		if err := job.WorkflowSelector.validate(); err != nil {
+			return fmt.Errorf("jobs[%d]: %w", i, err)
+		}
+		if err := validateGlobs(job.Jobs); err != nil {
+			return fmt.Errorf("jobs[%d]: jobs: %w", i, err)
+		}
+		if err := validatePermissions(job.Permissions); err != nil {
+			return fmt.Errorf("jobs[%d]: %w", i, err)
+		}
+	}
+	return nil
+}
+
+// defaults returns the top-level permissions.
+func (t *PermissionsTransform) defaults() map[string]string {
+	if len(t.Default) > 0 {
+		return t.Default
+	}
+	return map[string]string{"contents": "read"}
+}
+
+// apply adds the permissions of a workflow and of its jobs.
+func (t *PermissionsTransform) apply(ctx transformContext, doc *yaml.Node) error {
+	root := documentRoot(doc)
+	if root == nil || !ctx.isWorkflow() {
+		return nil
+	}
+	defaults := t.defaults()
+	if top := mappingValue(root, "permissions"); top != nil {
+		defaults = workflowPermissions(top)
+	} else {
+		insertMappingValue(root, "jobs", "permissions", permissionsNode(defaults))
+	}
+	forEachJob(root, func(id string, job *yaml.Node) {
+		if override := t.jobPermissions(ctx, id); override != nil {
+			setMappingValue(job, "permissions", permissionsNode(override))
+			return
+		}
+		if mappingValue(job, "permissions") != nil {
+			return
+		}
+		if uses := mappingValue(job, "uses"); uses != nil {
+			ctx.report("job %s calls %s, whose permissions are not derived; it gets those of the workflow unless jobs sets them", id, uses.Value)
+			return
+		}
+		granted := map[string]string{}
+		var unknown []string
+		var steps []*yaml.Node
+		if list := mappingValue(job, "steps"); list != nil && list.Kind == yaml.SequenceNode {
+			steps = list.Content
+		}
+		for _, step := range steps {
+			uses := mappingValue(step, "uses")
+			if uses == nil || uses.Kind != yaml.ScalarNode {
+				continue
+			}
+			scopes, ok := t.actionPermissions(uses.Value)
+			if !ok {
+				unknown = append(unknown, uses.Value)
+				continue
+			}
+			for _, scope := range sortedKeys(scopes) {
+				if permissionLevels[scopes[scope]] > permissionLevels[defaults[scope]] &&
+					permissionLevels[scopes[scope]] > permissionLevels[granted[scope]] {
+					granted[scope] = scopes[scope]
+					ctx.report("granting job %s %s: %s for %s", id, scope, scopes[scope], uses.Value)
+				}
+			}
+		}
+		for _, uses := range unknown {
+			if isRemoteAction(uses) {
+				ctx.report("job %s uses %s, whose permissions are unknown", id, uses)
+			} else {
+				ctx.report("job %s uses %s, whose permissions are not derived; list it under actions to grant them", id, uses)
+			}
+		}
+		if len(granted) == 0 {
+			return
+		}
+		for scope, level := range defaults {
+			if _, ok := granted[scope]; !ok {
+				granted[scope] = level
+			}
+		}
+		setMappingValue(job, "permissions", permissionsNode(granted))
+	})
+	return nil
+}
+
+// workflowPermissions returns the levels of the top-level permissions of
+// a workflow, expanding read-all and write-all.
+func workflowPermissions(node *yaml.Node) map[string]string {
+	permissions := map[string]string{}
+	if node.Kind == yaml.ScalarNode {
+		level := strings.TrimSuffix(node.Value, "-all")
+		if _, ok := permissionLevels[level]; ok {
+			for scope := range permissionScopes {
+				permissions[scope] = level
+			}
+		}
+		return permissions
+	}
+	mappingEntries(node, func(scope string, level *yaml.Node) {
+		permissions[scope] = level.Value
+	})
+	return permissions
+}
+
+// jobPermissions returns the permissions configured for a job, or nil.
+func (t *PermissionsTransform) jobPermissions(ctx transformContext, id string) map[string]string {
+	for _, job := range t.Jobs {
+		if job.matches(ctx.channel, ctx.path) && matchingGlob(job.Jobs, id) != "" {
+			return job.Permissions
+		}
+	}
+	return nil
+}
+
+// actionPermissions returns the scopes a step using uses needs: those of
+// the matching action globs of the configuration, or else the known ones of
+// a remote action. The scopes of local actions and Docker images are not
+// derived from their steps, so they are only known when configured.
+func (t *PermissionsTransform) actionPermissions(uses string) (map[string]string, bool) {
+	var scopes map[string]string
+	for _, glob := range sortedKeys(t.Actions) {
+		if !matchesReference(glob, uses) {
+			continue
+		}
+		if scopes == nil {
+			scopes = map[string]string{}
+		}
+		for scope, level := range t.Actions[glob] {
+			if permissionLevels[level] > permissionLevels[scopes[scope]] {
+				scopes[scope] = level
+			}
+		}
+	}
+	if scopes != nil {
+		return scopes, true
+	}
+	action, _, _ := strings.Cut(uses, "@")
+	scopes, ok := knownPermissions[action]
+	return scopes, ok
+}
+
+// permissionsNode returns permissions as a mapping in scope order.
+func permissionsNode(permissions map[string]string) *yaml.Node {
+	return sortedStrings(permissions, nil)
+}
@@ -0,0 +1,131 @@
+package cmd
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v3"
+)
+
+func TestPermissionsTransform(t *testing.T) {
+	fsys := newMemFS()
+	assert.NoError(t, fsys.WriteFile(DefaultPermissionsFile, []byte(`actions:
+    ./.github/actions/conditional: {pull-requests: read}
+    actions/checkout: {contents: write}
+jobs:
+    - jobs: [release]
+      permissions: {contents: write}
+`), 0644))
+	transform := &PermissionsTransform{Actions: map[string]map[string]string{"actions/checkout": {"contents": "read"}}}
+	assert.NoError(t, transform.load(fsys))
+	assert.NoError(t, transform.validate())
+
+	var doc yaml.Node
+	assert.NoError(t, yaml.Unmarshal([]byte(`name: CI
+on: [push]
+jobs:
+    conditional:
+        steps:
+            - uses: actions/checkout@v3
+            - uses: ./.github/actions/conditional
+    build:
+        steps:
+            - uses: actions/checkout@v3
+            - uses: ./.github/actions/build-keycloak
+            - uses: actions/upload-artifact@v3
+    report:
+        steps:
+            - uses: EnricoMi/publish-unit-test-result-action@v2
+            - uses: someone/unknown@v1
+    release:
+        steps:
+            - run: gh release create
+    labels:
+        permissions:
+            issues: write
+        steps:
+            - uses: actions/labeler@v4
+    shared:
+        uses: ./.github/workflows/shared.yml
+`), &doc))
+
+	var out bytes.Buffer
+	ctx := transformContext{channel: Channel{Name: "master"}, path: "master/build/.github/workflows/ci.yml", out: &out}
+	assert.NoError(t, transform.apply(ctx, &doc))
+
+	data, err := yaml.Marshal(&doc)
+	assert.NoError(t, err)
+	assert.Equal(t, `name: CI
+on: [push]
+permissions:
+    contents: read
+jobs:
+    conditional:
+        steps:
+            - uses: actions/checkout@v3
+            - uses: ./.github/actions/conditional
+        permissions:
+            contents: read
+            pull-requests: read
+    build:
+        steps:
+            - uses: actions/checkout@v3
+            - uses: ./.github/actions/build-keycloak
+            - uses: actions/upload-artifact@v3
+    report:
+        steps:
+            - uses: EnricoMi/publish-unit-test-result-action@v2
+            - uses: someone/unknown@v1
+        permissions:
+            checks: write
+            contents: read
+            pull-requests: write
+    release:
+        steps:
+            - run: gh release create
+        permissions:
+            contents: write
+    labels:
+        permissions:
+            issues: write
+        steps:
+            - uses: actions/labeler@v4
+    shared:
+        uses: ./.github/workflows/shared.yml
+`, string(data))
+	assert.Equal(t, ""+
+		"master/build/.github/workflows/ci.yml: granting job conditional pull-requests: read for ./.github/actions/conditional \n"+
+		"master/build/.github/workflows/ci.yml: job build uses ./.github/actions/build-keycloak, whose permissions are not derived; list it under actions to grant them \n"+
+		"master/build/.github/workflows/ci.yml: granting job report checks: write for EnricoMi/publish-unit-test-result-action@v2 \n"+
+		"master/build/.github/workflows/ci.yml: granting job report pull-requests: write for EnricoMi/publish-unit-test-result-action@v2 \n"+
+		"master/build/.github/workflows/ci.yml: job report uses someone/unknown@v1, whose permissions are unknown \n"+
+		"master/build/.github/workflows/ci.yml: job shared calls ./.github/workflows/shared.yml, whose permissions are not derived; it gets those of the workflow unless jobs sets them \n",
+		out.String())
+}
+
+func TestPermissionsKeepUpstreamDefaults(t *testing.T) {
+	var doc yaml.Node
+	assert.NoError(t, yaml.Unmarshal([]byte(`permissions: write-all
+jobs:
+    comment:
+        steps:
+            - uses: peter-evans/create-or-update-comment@v3
+`), &doc))
+	ctx := transformContext{channel: Channel{Name: "master"}, path: "master/build/.github/workflows/pr.yml", out: &bytes.Buffer{}}
+	assert.NoError(t, (&PermissionsTransform{}).apply(ctx, &doc))
+	data, err := yaml.Marshal(&doc)
+	assert.NoError(t, err)
+	assert.Equal(t, `permissions: write-all
+jobs:
+    comment:
+        steps:
+            - uses: peter-evans/create-or-update-comment@v3
+`, string(data))
+}
+
+func TestPermissionsValidation(t *testing.T) {
+	assert.NoError(t, (&PermissionsTransform{Default: map[string]string{"contents": "read"}}).validate())
+	assert.EqualError(t, (&PermissionsTransform{Default: map[string]string{"content": "read"}}).validate(), "default: unknown permission content")
+	assert.EqualError(t, (&PermissionsTransform{Jobs: []JobPermissions{{Permissions: map[string]string{"issues": "admin"}}}}).validate(), "jobs[0]: permission issues: level must be read, write or none")
+}
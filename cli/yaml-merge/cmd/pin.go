@@ -0,0 +1,150 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultPinFile is the pin file read when the pin transform names none.
+const DefaultPinFile = "action-pins.yaml"
+
+// PinTransform pins the actions and reusable workflows referenced by tag or
+// branch to commit SHAs. The pins map references such as actions/checkout@v3
+// to the SHA the ref pointed at when it was reviewed; a pinned reference
+// keeps the ref as a trailing comment.
+type PinTransform struct {
+	// File is the pin file, a mapping of references to SHAs read from the
+	// workspace root; DefaultPinFile by default.
+	File string `yaml:"file"`
+	// Check fails the merge of a file referencing an action that is not
+	// pinned, or pinned to a SHA the pins do not record, instead of
+	// reporting it.
+	Check bool `yaml:"check"`
+	// Pins holds pins in addition to those of the file, which it overrides.
+	Pins map[string]string `yaml:"pins"`
+}
+
+// load adds the pins of the pin file to t.Pins. A missing file is only an
+// error when it was named in the configuration.
+func (t *PinTransform) load(fsys fs.ReadFileFS) error {
+	name := t.File
+	if name == "" {
+		name = DefaultPinFile
+	}
+	data, err := fsys.ReadFile(name)
+	if errors.Is(err, fs.ErrNotExist) && t.File == "" {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var pins map[string]string
+	if err := yaml.Unmarshal(data, &pins); err != nil {
+		return fmt.Errorf("parsing %s: %w", name, err)
+	}
+	if t.Pins == nil {
+		t.Pins = map[string]string{}
+	}
+	for ref, sha := range pins {
+		if _, ok := t.Pins[ref]; !ok {
+			t.Pins[ref] = sha
+		}
+	}
+	return nil
+}
+
+// validate checks that every pin maps a reference with a ref to a SHA.
+func (t *PinTransform) validate() error {
+	refs := make([]string, 0, len(t.Pins))
+	for ref := range t.Pins {
+		refs = append(refs, ref)
+	}
+	sort.Strings(refs)
+	for _, ref := range refs {
+		if _, _, ok := strings.Cut(ref, "@"); !ok {
+			return fmt.Errorf("pin %s: missing @ref", ref)
+		}
+		if !commitPattern.MatchString(t.Pins[ref]) {
+			return fmt.Errorf("pin %s: %q is not a commit SHA", ref, t.Pins[ref])
+		}
+	}
+	return nil
+}
+
+// apply pins the references of the steps and reusable workflow jobs of a
+// workflow or composite action.
+func (t *PinTransform) apply(ctx transformContext, doc *yaml.Node) error {
+	root := documentRoot(doc)
+	if root == nil || !(ctx.isWorkflow() || ctx.isAction()) {
+		return nil
+	}
+	var problems []string
+	pin := func(node *yaml.Node) {
+		if problem := t.pin(mappingValue(node, "uses")); problem != "" {
+			problems = append(problems, problem)
+		}
+	}
+	forEachJob(root, func(_ string, job *yaml.Node) {
+		pin(job)
+	})
+	forEachStep(root, pin)
+
+	if len(problems) == 0 {
+		return nil
+	}
+	if t.Check {
+		return fmt.Errorf("actions not pinned: %s", strings.Join(problems, ", "))
+	}
+	for _, problem := range problems {
+		ctx.report("action not pinned: %s", problem)
+	}
+	return nil
+}
+
+// pin pins the reference of a uses value in place. It returns a description
+// of the reference when it cannot be pinned, or "".
+func (t *PinTransform) pin(uses *yaml.Node) string {
+	if uses == nil || uses.Kind != yaml.ScalarNode || !isRemoteAction(uses.Value) {
+		return ""
+	}
+	action, ref, ok := strings.Cut(uses.Value, "@")
+	if !ok {
+		return uses.Value + " (no ref)"
+	}
+	if commitPattern.MatchString(ref) {
+		if t.pinsSHA(action, ref) {
+			return ""
+		}
+		return uses.Value + " (unknown SHA)"
+	}
+	sha, ok := t.Pins[uses.Value]
+	if !ok {
+		return uses.Value + " (no pin)"
+	}
+	uses.Value = action + "@" + sha
+	uses.Style = 0
+	uses.LineComment = "# " + ref
+	return ""
+}
+
+// pinsSHA reports whether sha is the pin of any ref of action.
+func (t *PinTransform) pinsSHA(action, sha string) bool {
+	for ref, pinned := range t.Pins {
+		if pinned == sha && strings.HasPrefix(ref, action+"@") {
+			return true
+		}
+	}
+	return false
+}
+
+// isRemoteAction reports whether a uses value references an action or
+// workflow of another repository, rather than a local path or a Docker
+// image.
+func isRemoteAction(uses string) bool {
+	return !strings.HasPrefix(uses, "./") && !strings.HasPrefix(uses, "docker://") && strings.Contains(uses, "/")
+}
@@ -0,0 +1,90 @@
+package cmd
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	checkoutSHA = "f43a0e5ff2bd294095638e18286ca9a3d1956744"
+	sshAgentSHA = "d4b9b8ff72958532804b70bbe600ad43b36d5f2e"
+)
+
+func TestPinTransform(t *testing.T) {
+	fsys := newMemFS()
+	assert.NoError(t, fsys.WriteFile("pins.yaml", []byte(`actions/checkout@v3: `+checkoutSHA+`
+webfactory/ssh-agent@v0.7.0: `+sshAgentSHA+`
+`), 0644))
+	pin := &PinTransform{File: "pins.yaml"}
+	assert.NoError(t, pin.load(fsys))
+	assert.NoError(t, pin.validate())
+
+	var doc yaml.Node
+	assert.NoError(t, yaml.Unmarshal([]byte(`jobs:
+    build:
+        steps:
+            - uses: actions/checkout@v3
+            - uses: 'webfactory/ssh-agent@v0.7.0'
+            - uses: webfactory/ssh-agent@`+sshAgentSHA+` # v0.7.0
+            - uses: runforesight/foresight-test-kit-action@v1.2.1
+            - uses: ./.github/actions/conditional
+            - uses: docker://alpine:3.17
+            - run: echo
+    shared:
+        uses: keycloak/keycloak/.github/workflows/shared.yml@main
+`), &doc))
+
+	var out bytes.Buffer
+	ctx := transformContext{channel: Channel{Name: "master"}, path: "master/build/.github/workflows/ci.yml", out: &out}
+	assert.NoError(t, pin.apply(ctx, &doc))
+
+	data, err := yaml.Marshal(&doc)
+	assert.NoError(t, err)
+	assert.Equal(t, `jobs:
+    build:
+        steps:
+            - uses: actions/checkout@`+checkoutSHA+` # v3
+            - uses: webfactory/ssh-agent@`+sshAgentSHA+` # v0.7.0
+            - uses: webfactory/ssh-agent@`+sshAgentSHA+` # v0.7.0
+            - uses: runforesight/foresight-test-kit-action@v1.2.1
+            - uses: ./.github/actions/conditional
+            - uses: docker://alpine:3.17
+            - run: echo
+    shared:
+        uses: keycloak/keycloak/.github/workflows/shared.yml@main
+`, string(data))
+	assert.Equal(t, ""+
+		"master/build/.github/workflows/ci.yml: action not pinned: keycloak/keycloak/.github/workflows/shared.yml@main (no pin) \n"+
+		"master/build/.github/workflows/ci.yml: action not pinned: runforesight/foresight-test-kit-action@v1.2.1 (no pin) \n",
+		out.String())
+}
+
+func TestPinTransformCheck(t *testing.T) {
+	var doc yaml.Node
+	assert.NoError(t, yaml.Unmarshal([]byte(`runs:
+    using: composite
+    steps:
+        - uses: actions/checkout@v3
+        - uses: actions/checkout@0000000000000000000000000000000000000000
+`), &doc))
+	pin := &PinTransform{Check: true, Pins: map[string]string{"actions/checkout@v3": checkoutSHA}}
+	ctx := transformContext{channel: Channel{Name: "master"}, path: "master/build/.github/actions/build/action.yml", out: &bytes.Buffer{}}
+	assert.EqualError(t, pin.apply(ctx, &doc), "actions not pinned: actions/checkout@0000000000000000000000000000000000000000 (unknown SHA)")
+}
+
+func TestPinTransformLoad(t *testing.T) {
+	fsys := newMemFS()
+	assert.NoError(t, (&PinTransform{}).load(fsys))
+	assert.Error(t, (&PinTransform{File: "missing.yaml"}).load(fsys))
+
+	assert.NoError(t, fsys.WriteFile(DefaultPinFile, []byte("actions/checkout@v3: "+checkoutSHA+"\n"), 0644))
+	pin := &PinTransform{Pins: map[string]string{"actions/checkout@v3": sshAgentSHA}}
+	assert.NoError(t, pin.load(fsys))
+	assert.Equal(t, map[string]string{"actions/checkout@v3": sshAgentSHA}, pin.Pins)
+
+	assert.EqualError(t, (&PinTransform{Pins: map[string]string{"actions/checkout": checkoutSHA}}).validate(), "pin actions/checkout: missing @ref")
+	assert.EqualError(t, (&PinTransform{Pins: map[string]string{"actions/checkout@v3": "v3"}}).validate(), `pin actions/checkout@v3: "v3" is not a commit SHA`)
+}
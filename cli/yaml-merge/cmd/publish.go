@@ -0,0 +1,326 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+// Directories of the repository root that GitHub reads workflows and
+// local actions from.
+const (
+	PublishedWorkflowsDir = ".github/workflows"
+	PublishedActionsDir   = ".github/actions"
+)
+
+// publishCmd copies the merged workflows of every selected version into the
+// repository's root .github/workflows.
+var publishCmd = &cobra.Command{
+	Use:   "publish <version>...",
+	Short: "publish merged workflows to .github/workflows",
+	Long: `Publish copies every merged workflow below a version's build folder to .github/workflows/<version>-<name>.yml in the workspace root, where GitHub runs it. References to local actions are pointed at .github/actions/<version>/, and the workflow entries of .github/actions/<version>/conditional/conditions are renamed to the published files. With a dispatcher section in the configuration, publish also generates a workflow that calls the published workflow of every version when its files change, and publishes the called workflows without the triggers the dispatcher runs them for.
+
+A published file that yaml-merge did not write, or that was edited by hand, is not overwritten unless --force is given. Published workflows whose build output is gone are reported, and deleted with --prune.`,
+	Args: cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		fsys := newDirFS(rootDir)
+		cfg, err := loadConfig(fsys, configFile, cmd.Flags().Changed("config"))
+		if err != nil {
+			return err
+		}
+		m := newMerger(fsys, cfg, cmd.OutOrStdout())
+		m.prune = prune
+		m.force = force
+		return m.publishVersions(args...)
+	},
+}
+
+// publishVersions resolves the version selectors and publishes the merged
+// workflows of every selected channel. Nothing is written unless every
+// channel publishes cleanly.
+func (m *merger) publishVersions(selectors ...string) error {
+	channels, err := resolveSelectors(m.fsys, m.cfg.Layout, selectors)
+	if err != nil {
+		return err
+	}
+	txn := newWriteTxn(m.fsys)
+	sources := map[string]string{}
+	for _, channel := range channels {
+		if err := m.publishChannel(txn, channel, sources); err != nil {
+			return err
+		}
+	}
+	if m.cfg.Dispatcher != nil {
+		if err := m.writeDispatcher(txn); err != nil {
+			return err
+		}
+	}
+	return txn.Commit()
+}
+
+// publishedName returns the name a merged workflow of channel is published
+// under, e.g. .github/workflows/v21-ci.yml.
+func publishedName(channel Channel, workflow string) string {
+	return path.Join(PublishedWorkflowsDir, channelSelector(channel)+"-"+path.Base(workflow))
+}
+
+// publishChannel stages the published workflows of channel in txn. sources
+// maps every name published so far to the file it was published from, so
+// two workflows claiming the same name are reported.
+func (m *merger) publishChannel(txn *writeTxn, channel Channel, sources map[string]string) error {
+	dir := path.Join(channel.Output, PublishedWorkflowsDir)
+	entries, err := m.fsys.ReadDir(dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		fmt.Fprintf(m.out, "No workflows to publish for %s \n", channel.Name)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	manifest, err := loadManifest(m.fsys, channel)
+	if err != nil {
+		return err
+	}
+
+	actions := path.Join(PublishedActionsDir, channelSelector(channel))
+	published := map[string]bool{}
+	renames := map[string]string{}
+	for _, entry := range entries {
+		ext := path.Ext(entry.Name())
+		if entry.IsDir() || (ext != ".yml" && ext != ".yaml") {
+			continue
+		}
+		source := path.Join(dir, entry.Name())
+		target := publishedName(channel, source)
+		if other, ok := sources[target]; ok {
+			return fmt.Errorf("%s and %s are both published as %s", other, source, target)
+		}
+		sources[target] = source
+
+		data, err := m.fsys.ReadFile(source)
+		if err != nil {
+			return err
+		}
+		data, dispatched, err := publishedWorkflow(data, source, actions, m.cfg.dispatchedEvents(source))
+		if err != nil {
+			return fmt.Errorf("publishing %s: %w", source, err)
+		}
+		if len(dispatched) > 0 {
+			fmt.Fprintf(m.out, "Removing the %s triggers of %s, the dispatcher calls it for them \n", strings.Join(dispatched, ", "), target)
+		}
+		if err := m.checkPublishTarget(target, manifest, source); err != nil {
+			return err
+		}
+		fmt.Fprintf(m.out, "Publishing %s as %s \n", source, target)
+		if err := txn.WriteFile(target, data, 0644); err != nil {
+			return err
+		}
+		published[target] = true
+		renames[path.Join(PublishedWorkflowsDir, entry.Name())] = target
+	}
+
+	if err := m.removeStalePublished(txn, channel, published); err != nil {
+		return err
+	}
+	return m.syncConditions(txn, channel, renames, published)
+}
+
+// publishedWorkflow returns the published form of a merged workflow: local
+// action references point at the version's copy of the actions below
+// actions, the dispatched events are removed from its triggers when it is
+// reusable, and the generated-file header is renewed for the new content.
+// It also returns the events it removed.
+func publishedWorkflow(data []byte, source, actions string, dispatched []string) ([]byte, []string, error) {
+	header, ok := parseGeneratedHeader(data)
+	if ok {
+		data = header.body
+	} else {
+		header.source = source
+	}
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, nil, err
+	}
+	rewriteScalars(&doc, func(s string) string {
+		return rewriteActionPaths(s, actions)
+	})
+	removed := removeDispatchedEvents(documentRoot(&doc), dispatched)
+	body, err := yaml.Marshal(&doc)
+	if err != nil {
+		return nil, nil, err
+	}
+	return withGeneratedHeader(body, header.source), removed, nil
+}
+
+// actionPathPattern matches a repository-relative .github/actions/ path at
+// the start of a word, optionally written as ./.github/actions/.
+var actionPathPattern = regexp.MustCompile(`(^|[\s'"(=]|\./)\.github/actions/`)
+
+// rewriteActionPaths points every .github/actions/ path in s at actions,
+// leaving paths that already do alone.
+func rewriteActionPaths(s, actions string) string {
+	matches := actionPathPattern.FindAllStringSubmatchIndex(s, -1)
+	if matches == nil {
+		return s
+	}
+	var b strings.Builder
+	last := 0
+	for _, loc := range matches {
+		b.WriteString(s[last:loc[1]])
+		last = loc[1]
+		if !strings.HasPrefix(s[loc[1]:], path.Base(actions)+"/") {
+			b.WriteString(path.Base(actions) + "/")
+		}
+	}
+	b.WriteString(s[last:])
+	return b.String()
+}
+
+// checkPublishTarget refuses to overwrite a published file that yaml-merge
+// did not write or that was edited by hand, unless m.force is set.
+func (m *merger) checkPublishTarget(target string, manifest *Manifest, source string) error {
+	existing, err := m.fsys.ReadFile(target)
+	if err != nil || m.force {
+		return nil
+	}
+	if _, ok := parseGeneratedHeader(existing); !ok {
+		return fmt.Errorf("%s exists and was not published by yaml-merge; rename it or rerun with --force", target)
+	}
+	if !editedByHand(existing, "") {
+		return nil
+	}
+	patch := source
+	if output, ok := manifest.output(source); ok && output.Patch != "" {
+		patch = output.Patch
+	}
+	return fmt.Errorf("%s was edited by hand; move the change into %s or rerun with --force", target, patch)
+}
+
+// removeStalePublished finds the workflows published for channel by an
+// earlier run whose build output is gone. They are deleted with m.prune and
+// reported otherwise.
+func (m *merger) removeStalePublished(txn *writeTxn, channel Channel, published map[string]bool) error {
+	entries, err := m.fsys.ReadDir(PublishedWorkflowsDir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	prefix := channelSelector(channel) + "-"
+	var stale []string
+	for _, entry := range entries {
+		name := path.Join(PublishedWorkflowsDir, entry.Name())
+		if entry.IsDir() || !strings.HasPrefix(entry.Name(), prefix) || published[name] {
+			continue
+		}
+		data, err := m.fsys.ReadFile(name)
+		if err != nil {
+			return err
+		}
+		if _, ok := parseGeneratedHeader(data); ok {
+			stale = append(stale, name)
+		}
+	}
+	if len(stale) == 0 {
+		return nil
+	}
+	if !m.prune {
+		fmt.Fprintf(m.out, "%s has %d stale published workflows, run with --prune to delete them \n", channel.Name, len(stale))
+		return nil
+	}
+	for _, name := range stale {
+		fmt.Fprintf(m.out, "Pruning %s \n", name)
+		if err := txn.Remove(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// conditionsFile returns the conditions file of the conditional action
+// vendored for channel.
+func conditionsFile(channel Channel) string {
+	return path.Join(PublishedActionsDir, channelSelector(channel), "conditional", "conditions")
+}
+
+// syncConditions renames the workflow entries of the channel's conditions
+// file to the published workflows. renames maps upstream workflow paths to
+// published ones; published lists every workflow published for the channel.
+// Entries naming a workflow that is not published are reported.
+func (m *merger) syncConditions(txn *writeTxn, channel Channel, renames map[string]string, published map[string]bool) error {
+	name := conditionsFile(channel)
+	data, err := m.fsys.ReadFile(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	lines := strings.Split(string(data), "\n")
+	listed := map[string]bool{}
+	var missing []string
+	for i, line := range lines {
+		fields := strings.Fields(line)
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") || path.Dir(fields[0]) != PublishedWorkflowsDir {
+			continue
+		}
+		pattern := fields[0]
+		if target, ok := renames[pattern]; ok {
+			lines[i] = renameConditionsEntry(line, pattern, target)
+			pattern = target
+		}
+		listed[pattern] = true
+		if !published[pattern] {
+			missing = append(missing, pattern)
+		}
+	}
+	for _, pattern := range missing {
+		fmt.Fprintf(m.out, "%s lists %s, which is not published \n", name, pattern)
+	}
+	var unlisted []string
+	for target := range published {
+		if !listed[target] {
+			unlisted = append(unlisted, target)
+		}
+	}
+	sort.Strings(unlisted)
+	for _, target := range unlisted {
+		fmt.Fprintf(m.out, "%s does not list %s \n", name, target)
+	}
+
+	synced := []byte(strings.Join(lines, "\n"))
+	if string(synced) == string(data) {
+		return nil
+	}
+	fmt.Fprintf(m.out, "Updating %s \n", name)
+	return txn.WriteFile(name, synced, 0644)
+}
+
+// renameConditionsEntry replaces the pattern at the start of a conditions
+// line, keeping the job list in its column where the new name allows.
+func renameConditionsEntry(line, pattern, target string) string {
+	start := strings.Index(line, pattern)
+	rest := line[start+len(pattern):]
+	jobs := strings.TrimLeft(rest, " \t")
+	column := start + len(pattern) + len(rest) - len(jobs)
+	padding := column - start - len(target)
+	if padding < 1 {
+		padding = 1
+	}
+	return line[:start] + target + strings.Repeat(" ", padding) + jobs
+}
+
+func init() {
+	publishCmd.Flags().BoolVar(&force, "force", false, "overwrite published workflows that were edited by hand or not written by yaml-merge")
+	publishCmd.Flags().BoolVar(&prune, "prune", false, "delete published workflows whose build output was removed")
+	rootCmd.AddCommand(publishCmd)
+}
@@ -0,0 +1,96 @@
+package cmd
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPublishWorkflows(t *testing.T) {
+	fsys := newMemFS()
+	for _, root := range []string{"master", "releases/v21/latest"} {
+		assert.NoError(t, fsys.WriteFile(root+"/keycloak/.github/workflows/ci.yml", []byte("name: CI\non:\n    push:\n        paths: ['.github/actions/**']\njobs:\n    build:\n        steps:\n            - uses: ./.github/actions/build-keycloak\n"), 0644))
+		assert.NoError(t, fsys.WriteFile(root+"/keycloak/.github/workflows/js-ci.yml", []byte("name: JS\n"), 0644))
+		assert.NoError(t, fsys.WriteFile(root+"/patches/.github/workflows/ci.yml", []byte("env: {A: 1}\n"), 0644))
+		assert.NoError(t, fsys.WriteFile(root+"/patches/.github/workflows/js-ci.yml", []byte("env: {A: 1}\n"), 0644))
+	}
+	assert.NoError(t, fsys.WriteFile(".github/actions/master/conditional/conditions", []byte(
+		"# patterns\n"+
+			".github/actions/master/                 ci js\n"+
+			".github/workflows/ci.yml                ci\n"+
+			".github/workflows/operator-ci.yml       operator\n"), 0644))
+	assert.NoError(t, newMerger(fsys, defaultConfig(), io.Discard).mergeVersions("all"))
+
+	var out bytes.Buffer
+	assert.NoError(t, newMerger(fsys, defaultConfig(), &out).publishVersions("all"))
+	assert.Contains(t, out.String(), "Publishing master/build/.github/workflows/ci.yml as .github/workflows/master-ci.yml")
+	assert.Contains(t, out.String(), ".github/actions/master/conditional/conditions lists .github/workflows/operator-ci.yml, which is not published")
+	assert.Contains(t, out.String(), ".github/actions/master/conditional/conditions does not list .github/workflows/master-js-ci.yml")
+
+	for _, name := range []string{"master-ci.yml", "master-js-ci.yml", "v21-ci.yml", "v21-js-ci.yml"} {
+		assert.True(t, fileExists(fsys, ".github/workflows/"+name), name)
+	}
+	data, err := fsys.ReadFile(".github/workflows/v21-ci.yml")
+	assert.NoError(t, err)
+	assert.Equal(t, "name: CI\non:\n    push:\n        paths: ['.github/actions/v21/**']\njobs:\n    build:\n        steps:\n            - uses: ./.github/actions/v21/build-keycloak\nenv: {A: 1}\n", generatedBody(t, data))
+
+	conditions, err := fsys.ReadFile(".github/actions/master/conditional/conditions")
+	assert.NoError(t, err)
+	assert.Equal(t, "# patterns\n"+
+		".github/actions/master/                 ci js\n"+
+		".github/workflows/master-ci.yml         ci\n"+
+		".github/workflows/operator-ci.yml       operator\n", string(conditions))
+
+	// publishing again changes nothing
+	assert.NoError(t, newMerger(fsys, defaultConfig(), io.Discard).publishVersions("all"))
+	again, _ := fsys.ReadFile(".github/workflows/v21-ci.yml")
+	assert.Equal(t, data, again)
+}
+
+func TestPublishCollisions(t *testing.T) {
+	fsys := newMemFS()
+	assert.NoError(t, fsys.WriteFile("master/build/.github/workflows/ci.yml", []byte("name: CI\n"), 0644))
+	assert.NoError(t, fsys.WriteFile(".github/workflows/master-ci.yml", []byte("name: Mine\n"), 0644))
+
+	err := newMerger(fsys, defaultConfig(), io.Discard).publishVersions("master")
+	assert.EqualError(t, err, ".github/workflows/master-ci.yml exists and was not published by yaml-merge; rename it or rerun with --force")
+
+	m := newMerger(fsys, defaultConfig(), io.Discard)
+	m.force = true
+	assert.NoError(t, m.publishVersions("master"))
+	data, _ := fsys.ReadFile(".github/workflows/master-ci.yml")
+	assert.Equal(t, "name: CI\n", generatedBody(t, data))
+
+	// hand edits of a published workflow are refused
+	assert.NoError(t, fsys.WriteFile(".github/workflows/master-ci.yml", append(data, "env: {}\n"...), 0644))
+	err = newMerger(fsys, defaultConfig(), io.Discard).publishVersions("master")
+	assert.EqualError(t, err, ".github/workflows/master-ci.yml was edited by hand; move the change into master/build/.github/workflows/ci.yml or rerun with --force")
+
+	// two workflows claiming the same name
+	channel, err := defaultConfig().Layout.Resolve("master")
+	assert.NoError(t, err)
+	sources := map[string]string{".github/workflows/master-ci.yml": "other/ci.yml"}
+	err = newMerger(fsys, defaultConfig(), io.Discard).publishChannel(newWriteTxn(fsys), channel, sources)
+	assert.EqualError(t, err, "other/ci.yml and master/build/.github/workflows/ci.yml are both published as .github/workflows/master-ci.yml")
+}
+
+func TestPublishPrunesStaleWorkflows(t *testing.T) {
+	fsys := newMemFS()
+	assert.NoError(t, fsys.WriteFile("master/build/.github/workflows/ci.yml", []byte("name: CI\n"), 0644))
+	assert.NoError(t, fsys.WriteFile("master/build/.github/workflows/docs.yml", []byte("name: Docs\n"), 0644))
+	assert.NoError(t, newMerger(fsys, defaultConfig(), io.Discard).publishVersions("master"))
+	assert.NoError(t, fsys.Remove("master/build/.github/workflows/docs.yml"))
+
+	var out bytes.Buffer
+	assert.NoError(t, newMerger(fsys, defaultConfig(), &out).publishVersions("master"))
+	assert.Contains(t, out.String(), "master has 1 stale published workflows, run with --prune to delete them")
+	assert.True(t, fileExists(fsys, ".github/workflows/master-docs.yml"))
+
+	m := newMerger(fsys, defaultConfig(), io.Discard)
+	m.prune = true
+	assert.NoError(t, m.publishVersions("master"))
+	assert.False(t, fileExists(fsys, ".github/workflows/master-docs.yml"))
+	assert.True(t, fileExists(fsys, ".github/workflows/master-ci.yml"))
+}
@@ -0,0 +1,231 @@
+package cmd
+
+import (
+	"path"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// RelocateTransform rewrites upstream workflows and actions, which assume
+// the upstream repository is the checkout root, for a checkout where it
+// lives in the channel's upstream directory (master/keycloak,
+// releases/v21/latest/keycloak). It relocates working directories,
+// hashFiles patterns, artifact and cache paths, -f/--file arguments passed
+// to actions and checkout paths, and checks out the upstream submodule.
+// Path-bearing fields it cannot rewrite are reported.
+type RelocateTransform struct{}
+
+// relocator rewrites the paths of one file into dir.
+type relocator struct {
+	dir string
+	ctx transformContext
+}
+
+// apply relocates a workflow or action into the channel's upstream
+// directory.
+func (t *RelocateTransform) apply(ctx transformContext, doc *yaml.Node) error {
+	root := documentRoot(doc)
+	if root == nil {
+		return nil
+	}
+	r := relocator{dir: ctx.channel.Upstream, ctx: ctx}
+	if ctx.isWorkflow() {
+		r.defaults(root, true)
+		forEachJob(root, func(_ string, job *yaml.Node) {
+			r.defaults(job, false)
+		})
+	}
+	forEachStep(root, func(step *yaml.Node) {
+		r.step(step, ctx.isAction())
+	})
+	rewriteScalars(root, r.hashFiles)
+	return nil
+}
+
+// defaults relocates defaults.run.working-directory of a workflow or job.
+// A workflow without one gets the upstream directory, so run steps start
+// where upstream expects them to.
+func (r relocator) defaults(node *yaml.Node, required bool) {
+	run := mappingValue(mappingValue(node, "defaults"), "run")
+	if dir := mappingValue(run, "working-directory"); dir != nil {
+		r.scalar(dir, "working-directory")
+		return
+	}
+	if !required {
+		return
+	}
+	defaults := mappingValue(node, "defaults")
+	if defaults == nil {
+		defaults = &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+		setMappingValue(node, "defaults", defaults)
+	}
+	if run == nil {
+		run = &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+		setMappingValue(defaults, "run", run)
+	}
+	setMappingValue(run, "working-directory", stringNode("./"+r.dir))
+}
+
+// step relocates the paths of one step. Run steps of composite actions do
+// not inherit the workflow defaults, so they get an explicit working
+// directory.
+func (r relocator) step(step *yaml.Node, action bool) {
+	if dir := mappingValue(step, "working-directory"); dir != nil {
+		r.scalar(dir, "working-directory")
+	} else if action && mappingValue(step, "run") != nil {
+		setMappingValue(step, "working-directory", stringNode("./"+r.dir))
+	}
+
+	uses := stepAction(step)
+	if uses == "" {
+		return
+	}
+	with := mappingValue(step, "with")
+	switch uses {
+	case "actions/checkout":
+		r.checkout(step)
+		return
+	case "actions/upload-artifact", "actions/download-artifact", "actions/cache", "actions/cache/restore", "actions/cache/save":
+		if p := mappingValue(with, "path"); p != nil && p.Kind == yaml.ScalarNode {
+			p.Value = r.pathList(p.Value, "path")
+		}
+		return
+	}
+	mappingEntries(with, func(key string, value *yaml.Node) {
+		if value.Kind != yaml.ScalarNode {
+			return
+		}
+		value.Value = r.fileArgs(value.Value, key)
+		lower := strings.ToLower(key)
+		if strings.HasSuffix(lower, "path") || strings.HasSuffix(lower, "paths") {
+			if relative(value.Value) && !r.under(value.Value) {
+				r.ctx.report("input %s of %s looks like a path, relocate it in the patch if it is relative to the repository: %s", key, uses, value.Value)
+			}
+		}
+	})
+}
+
+// checkout makes actions/checkout fetch the upstream submodule, and
+// relocates a checkout of another repository into the upstream directory.
+func (r relocator) checkout(step *yaml.Node) {
+	with := mappingValue(step, "with")
+	if mappingValue(with, "repository") != nil {
+		if p := mappingValue(with, "path"); p != nil {
+			r.scalar(p, "checkout path")
+		} else {
+			r.ctx.report("checkout of %s without a path replaces the workspace", mappingValue(with, "repository").Value)
+		}
+		return
+	}
+	if with == nil {
+		with = &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+		setMappingValue(step, "with", with)
+	}
+	// upstream's own submodules are one level deeper now
+	submodules := mappingValue(with, "submodules")
+	switch {
+	case submodules == nil || submodules.Value == "false" || submodules.Value == "":
+		setMappingValue(with, "submodules", &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!bool", Value: "true"})
+	case submodules.Value == "true":
+		setMappingValue(with, "submodules", stringNode("recursive"))
+	case submodules.Value != "recursive":
+		r.ctx.report("cannot relocate checkout submodules: %s", submodules.Value)
+	}
+}
+
+// scalar relocates a single path held by node.
+func (r relocator) scalar(node *yaml.Node, what string) {
+	if node.Kind != yaml.ScalarNode {
+		r.ctx.report("cannot relocate %s", what)
+		return
+	}
+	node.Value = r.path(node.Value, what)
+}
+
+// relative reports whether p is a path relative to the workspace. Absolute
+// paths, paths below the home directory and paths starting with a variable
+// or expression are explicit and left alone.
+func relative(p string) bool {
+	return p != "" && !strings.HasPrefix(p, "/") && !strings.HasPrefix(p, "~") &&
+		!strings.HasPrefix(p, "$") && !strings.HasPrefix(p, "${{")
+}
+
+// under reports whether the relative path p already points into r.dir.
+func (r relocator) under(p string) bool {
+	p = strings.TrimPrefix(p, "./")
+	return p == r.dir || strings.HasPrefix(p, r.dir+"/")
+}
+
+// path relocates a relative path or glob into r.dir. A path computed by an
+// expression cannot be followed and is reported, unless it starts at the
+// workspace.
+func (r relocator) path(p, what string) string {
+	negated := strings.HasPrefix(p, "!")
+	p = strings.TrimPrefix(p, "!")
+	if !relative(p) || r.under(p) {
+		if strings.HasPrefix(p, "${{") && !strings.HasPrefix(p, "${{ github.workspace }}") && !strings.HasPrefix(p, "${{github.workspace}}") {
+			r.ctx.report("cannot relocate %s: %s", what, p)
+		}
+		if negated {
+			return "!" + p
+		}
+		return p
+	}
+	relocated := path.Join(r.dir, p)
+	if strings.HasSuffix(p, "/") {
+		relocated += "/"
+	}
+	if strings.HasPrefix(p, "./") || p == "." {
+		relocated = "./" + relocated
+	}
+	if negated {
+		relocated = "!" + relocated
+	}
+	return relocated
+}
+
+// pathList relocates every line of a multi-line path input.
+func (r relocator) pathList(value, what string) string {
+	lines := strings.Split(value, "\n")
+	for i, line := range lines {
+		if trimmed := strings.TrimSpace(line); trimmed != "" {
+			lines[i] = strings.Replace(line, trimmed, r.path(trimmed, what), 1)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// fileArgPattern matches the file argument of a Maven-style -f or --file
+// option.
+var fileArgPattern = regexp.MustCompile(`(^|\s)(-f|--file)(\s+|=)(\S+)`)
+
+// fileArgs relocates the -f/--file arguments in an action input.
+func (r relocator) fileArgs(value, key string) string {
+	return fileArgPattern.ReplaceAllStringFunc(value, func(match string) string {
+		parts := fileArgPattern.FindStringSubmatch(match)
+		return parts[1] + parts[2] + parts[3] + r.path(parts[4], "input "+key)
+	})
+}
+
+// hashFilesPattern matches a hashFiles call with its argument list.
+var hashFilesPattern = regexp.MustCompile(`hashFiles\(([^)]*)\)`)
+
+// hashFiles relocates the patterns of every hashFiles call in s. They are
+// matched against the workspace, not the working directory.
+func (r relocator) hashFiles(s string) string {
+	return hashFilesPattern.ReplaceAllStringFunc(s, func(call string) string {
+		args := strings.Split(hashFilesPattern.FindStringSubmatch(call)[1], ",")
+		for i, arg := range args {
+			trimmed := strings.TrimSpace(arg)
+			if len(trimmed) < 2 || trimmed[0] != '\'' || trimmed[len(trimmed)-1] != '\'' {
+				r.ctx.report("cannot relocate hashFiles argument %s", trimmed)
+				continue
+			}
+			pattern := trimmed[1 : len(trimmed)-1]
+			args[i] = strings.Replace(arg, trimmed, "'"+r.path(pattern, "hashFiles pattern")+"'", 1)
+		}
+		return "hashFiles(" + strings.Join(args, ",") + ")"
+	})
+}
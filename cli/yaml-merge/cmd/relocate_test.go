@@ -0,0 +1,130 @@
+package cmd
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v3"
+)
+
+func TestRelocateTransform(t *testing.T) {
+	fsys := newMemFS()
+	assert.NoError(t, fsys.WriteFile("master/keycloak/.github/workflows/ci.yml", []byte(`name: CI
+jobs:
+    build:
+        steps:
+            - uses: actions/checkout@v3
+            - uses: actions/checkout@v3
+              with:
+                submodules: true
+            - uses: actions/cache@v3
+              with:
+                path: |
+                    ~/.m2/repository
+                    .mvn/wrapper/maven-wrapper.jar
+                key: maven-${{ hashFiles('**/pom.xml', '!**/target/**') }}
+            - run: ./mvnw install
+              working-directory: quarkus
+            - uses: ./.github/actions/integration-test-setup
+              with:
+                maven-args: -B -f testsuite/integration-arquillian/pom.xml
+                surefire-reports-path: testsuite/target/surefire-reports/*.xml
+            - uses: actions/upload-artifact@v3
+              with:
+                path: ${{ steps.build.outputs.dir }}
+    docs:
+        defaults:
+            run:
+                working-directory: docs
+        steps:
+            - uses: actions/checkout@v3
+              with:
+                repository: keycloak/keycloak-documentation
+                path: documentation
+`), 0644))
+	assert.NoError(t, fsys.WriteFile("master/keycloak/.github/actions/build/action.yml", []byte(`name: Build
+runs:
+    using: composite
+    steps:
+        - run: ./mvnw install
+          shell: bash
+`), 0644))
+	assert.NoError(t, fsys.WriteFile("master/patches/.github/workflows/ci.yml", []byte("env: {A: 1}\n"), 0644))
+	assert.NoError(t, fsys.WriteFile("master/patches/.github/actions/build/action.yml", []byte("description: build\n"), 0644))
+
+	cfg := defaultConfig()
+	cfg.Transforms.Relocate = &RelocateTransform{}
+	var out bytes.Buffer
+	assert.NoError(t, newMerger(fsys, cfg, &out).mergeVersions("master"))
+	assert.Contains(t, out.String(), "master/build/.github/workflows/ci.yml: input surefire-reports-path of ./.github/actions/integration-test-setup looks like a path, relocate it in the patch if it is relative to the repository: testsuite/target/surefire-reports/*.xml")
+	assert.Contains(t, out.String(), "master/build/.github/workflows/ci.yml: cannot relocate path: ${{ steps.build.outputs.dir }}")
+
+	data, err := fsys.ReadFile("master/build/.github/workflows/ci.yml")
+	assert.NoError(t, err)
+	assert.Equal(t, `name: CI
+jobs:
+    build:
+        steps:
+            - uses: actions/checkout@v3
+              with:
+                submodules: true
+            - uses: actions/checkout@v3
+              with:
+                submodules: recursive
+            - uses: actions/cache@v3
+              with:
+                path: |
+                    ~/.m2/repository
+                    master/keycloak/.mvn/wrapper/maven-wrapper.jar
+                key: maven-${{ hashFiles('master/keycloak/**/pom.xml', '!master/keycloak/**/target/**') }}
+            - run: ./mvnw install
+              working-directory: master/keycloak/quarkus
+            - uses: ./.github/actions/integration-test-setup
+              with:
+                maven-args: -B -f master/keycloak/testsuite/integration-arquillian/pom.xml
+                surefire-reports-path: testsuite/target/surefire-reports/*.xml
+            - uses: actions/upload-artifact@v3
+              with:
+                path: ${{ steps.build.outputs.dir }}
+    docs:
+        defaults:
+            run:
+                working-directory: master/keycloak/docs
+        steps:
+            - uses: actions/checkout@v3
+              with:
+                repository: keycloak/keycloak-documentation
+                path: master/keycloak/documentation
+env: {A: 1}
+defaults:
+    run:
+        working-directory: ./master/keycloak
+`, generatedBody(t, data))
+
+	data, err = fsys.ReadFile("master/build/.github/actions/build/action.yml")
+	assert.NoError(t, err)
+	assert.Contains(t, generatedBody(t, data), "        - run: ./mvnw install\n          shell: bash\n          working-directory: ./master/keycloak\n")
+}
+
+func TestRelocatorPath(t *testing.T) {
+	r := relocator{dir: "releases/v21/latest/keycloak", ctx: transformContext{out: &bytes.Buffer{}}}
+	for p, want := range map[string]string{
+		".":                               "./releases/v21/latest/keycloak",
+		"./":                              "./releases/v21/latest/keycloak/",
+		"pom.xml":                         "releases/v21/latest/keycloak/pom.xml",
+		"!**/target":                      "!releases/v21/latest/keycloak/**/target",
+		"./releases/v21/latest/keycloak":  "./releases/v21/latest/keycloak",
+		"/tmp/reports":                    "/tmp/reports",
+		"~/.m2":                           "~/.m2",
+		"${{ github.workspace }}/reports": "${{ github.workspace }}/reports",
+	} {
+		assert.Equal(t, want, r.path(p, "path"), p)
+	}
+	assert.Empty(t, r.ctx.out.(*bytes.Buffer).String())
+
+	var doc yaml.Node
+	assert.NoError(t, yaml.Unmarshal([]byte("key: ${{ hashFiles(env.FILES) }}\n"), &doc))
+	rewriteScalars(&doc, r.hashFiles)
+	assert.Contains(t, r.ctx.out.(*bytes.Buffer).String(), "cannot relocate hashFiles argument env.FILES")
+}
@@ -1,32 +1,29 @@
 package cmd
 
 import (
+	"bytes"
+	"io"
 	"os"
+	"path"
 	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v3"
 )
 
 func TestYamlMergeCommand(t *testing.T) {
 	// GIVEN
-	// Create a temporary test directory
+	// Create an in-memory workspace
 	const version = "v20"
-	tmpDir := filepath.Join(ReleasesDir, version, LatestDir)
-	err := os.MkdirAll(tmpDir, os.ModePerm)
-	assert.NoError(t, err)
-	//defer os.RemoveAll("releases")
+	fsys := newMemFS()
+	tmpDir := path.Join("releases", version, "latest")
 
 	// Create test files
-	downstreamFile := filepath.Join(tmpDir, PatchesDir, "/.github/workflows/ci.yml")
-	upstreamFile := filepath.Join(tmpDir, KeycloakDir, "/.github/workflows/ci.yml")
-	devFile := filepath.Join(tmpDir, "dev/.github/workflows/ci.yml")
-	err = os.MkdirAll(filepath.Dir(downstreamFile), os.ModePerm)
-	assert.NoError(t, err)
-	err = os.MkdirAll(filepath.Dir(upstreamFile), os.ModePerm)
-	assert.NoError(t, err)
-	//err = os.MkdirAll(filepath.Dir(devFile), os.ModePerm)
-	//assert.NoError(t, err)
+	downstreamFile := path.Join(tmpDir, DefaultPatchesDir, ".github/workflows/ci.yml")
+	upstreamFile := path.Join(tmpDir, DefaultUpstreamDir, ".github/workflows/ci.yml")
+	devFile := path.Join(tmpDir, DefaultOutputDir, ".github/workflows/ci.yml")
 	downstreamData := []byte(`
 on:
   workflow_call:
@@ -39,7 +36,8 @@ on:
         required: true
 
 `)
-	os.WriteFile(downstreamFile, downstreamData, os.ModePerm)
+	err := fsys.WriteFile(downstreamFile, downstreamData, 0644)
+	assert.NoError(t, err)
 
 	upstreamData := []byte(`
 name: Keycloak CI
@@ -675,16 +673,15 @@ jobs:
 #          path: reports-webauthn-tests.zip
 #          if-no-files-found: ignore
 `)
-	os.WriteFile(upstreamFile, upstreamData, os.ModePerm)
+	err = fsys.WriteFile(upstreamFile, upstreamData, 0644)
+	assert.NoError(t, err)
 
 	// Run the command
-	args := []string{version}
-	rootCmd.SetArgs(args)
-	err = rootCmd.RunE(nil, args)
+	err = newMerger(fsys, defaultConfig(), io.Discard).mergeVersions(version)
 	assert.NoError(t, err)
 
 	// Assert that the dev file was written correctly
-	devData, err := os.ReadFile(devFile)
+	devData, err := fsys.ReadFile(devFile)
 	assert.NoError(t, err)
 
 	expectedData := `name: Keycloak CI
@@ -1263,5 +1260,213 @@ jobs:
 #          path: reports-webauthn-tests.zip
 #          if-no-files-found: ignore
 `
-	assert.Equal(t, expectedData, string(devData))
+	assert.Equal(t, expectedData, generatedBody(t, devData))
+}
+
+func TestRootFlagResolvesWorkspace(t *testing.T) {
+	root := t.TempDir()
+	patch := filepath.Join(root, "master", DefaultPatchesDir, ".github", "workflows", "ci.yml")
+	upstream := filepath.Join(root, "master", DefaultUpstreamDir, ".github", "workflows", "ci.yml")
+	assert.NoError(t, os.MkdirAll(filepath.Dir(patch), os.ModePerm))
+	assert.NoError(t, os.MkdirAll(filepath.Dir(upstream), os.ModePerm))
+	assert.NoError(t, os.WriteFile(patch, []byte("env:\n  B: 2\n"), 0644))
+	assert.NoError(t, os.WriteFile(upstream, []byte("env:\n  A: 1\n"), 0644))
+
+	var out bytes.Buffer
+	rootCmd.SetOut(&out)
+	rootCmd.SetArgs([]string{"--root", root, "master"})
+	assert.NoError(t, rootCmd.Execute())
+
+	merged, err := os.ReadFile(filepath.Join(root, "master", DefaultOutputDir, ".github", "workflows", "ci.yml"))
+	assert.NoError(t, err)
+	assert.Equal(t, "env:\n    A: 1\n    B: 2\n", generatedBody(t, merged))
+	assert.Contains(t, out.String(), "targetPath master/build/.github/workflows/ci.yml")
+}
+
+func TestMissingUpstreamIsLoggedUnderRoot(t *testing.T) {
+	fsys := newMemFS()
+	assert.NoError(t, fsys.WriteFile("master/patches/.github/workflows/extra.yml", []byte("a: 1\n"), 0644))
+
+	err := newMerger(fsys, defaultConfig(), io.Discard).mergeVersions("master")
+	assert.NoError(t, err)
+
+	logData, err := fsys.ReadFile("error.log")
+	assert.NoError(t, err)
+	assert.Contains(t, string(logData), "File not found: master/keycloak/.github/workflows/extra.yml")
+	assert.False(t, fileExists(fsys, "master/build/.github/workflows/extra.yml"))
+}
+
+func TestOverridesReplaceUpstream(t *testing.T) {
+	fsys := newMemFS()
+	assert.NoError(t, fsys.WriteFile("releases/v21/latest/patches/.github/workflows/ci.yml", []byte("env:\n  B: 2\n"), 0644))
+	assert.NoError(t, fsys.WriteFile("releases/v21/latest/keycloak/.github/workflows/ci.yml", []byte("env:\n  A: 1\n"), 0644))
+	assert.NoError(t, fsys.WriteFile("releases/v21/latest/overrides/.github/workflows/ci.yml", []byte("env:\n  O: 0\n"), 0644))
+
+	err := newMerger(fsys, defaultConfig(), io.Discard).mergeVersions("v21")
+	assert.NoError(t, err)
+
+	merged, err := fsys.ReadFile("releases/v21/latest/build/.github/workflows/ci.yml")
+	assert.NoError(t, err)
+	assert.Equal(t, "env:\n    O: 0\n    B: 2\n", generatedBody(t, merged))
+}
+
+func TestMergeAllChannelsConcurrently(t *testing.T) {
+	newWorkspace := func() *memFS {
+		fsys := newMemFS()
+		for _, root := range []string{"master", "releases/v20/latest", "releases/v20/0/latest", "releases/v21/latest"} {
+			for _, name := range []string{"ci", "js-ci", "operator-ci", "docs"} {
+				file := ".github/workflows/" + name + ".yml"
+				assert.NoError(t, fsys.WriteFile(path.Join(root, "keycloak", file), []byte("name: "+name+"\non:\n  push: {}\n"), 0644))
+				assert.NoError(t, fsys.WriteFile(path.Join(root, "patches", file), []byte("env:\n  ROOT: "+root+"\n"), 0644))
+			}
+		}
+		// a broken patch must not stop the other channels
+		assert.NoError(t, fsys.WriteFile("releases/v21/latest/patches/.github/workflows/broken.yml", []byte("env: [\n"), 0644))
+		assert.NoError(t, fsys.WriteFile("releases/v21/latest/keycloak/.github/workflows/broken.yml", []byte("env: {}\n"), 0644))
+		return fsys
+	}
+
+	sequential, parallel := newWorkspace(), newWorkspace()
+	var sequentialOut, parallelOut bytes.Buffer
+	m := newMerger(sequential, defaultConfig(), &sequentialOut)
+	m.jobs = 1
+	sequentialErr := m.mergeVersions(SelectorAll)
+	m = newMerger(parallel, defaultConfig(), &parallelOut)
+	m.jobs = 8
+	parallelErr := m.mergeVersions(SelectorAll)
+
+	assert.EqualError(t, parallelErr, sequentialErr.Error())
+	assert.Contains(t, parallelErr.Error(), `Error parsing "releases/v21/latest/patches/.github/workflows/broken.yml"`)
+	assert.Equal(t, sequentialOut.String(), parallelOut.String())
+
+	merged, err := parallel.ReadFile("releases/v20/0/latest/build/.github/workflows/js-ci.yml")
+	assert.NoError(t, err)
+	assert.Equal(t, "name: js-ci\non:\n    push: {}\nenv:\n    ROOT: releases/v20/0/latest\n", generatedBody(t, merged))
+	// the broken patch rolls back the whole v21 channel, not the others
+	assert.False(t, fileExists(parallel, "releases/v21/latest/build/.github/workflows/ci.yml"))
+	assert.Contains(t, parallelOut.String(), "Rolled back v21: 4 generated files discarded")
+	assert.True(t, fileExists(parallel, "releases/v20/latest/build/.github/workflows/ci.yml"))
+}
+
+// panickingFS panics when a file whose name contains panicOn is read.
+type panickingFS struct {
+	WritableFS
+	panicOn string
+}
+
+func (p panickingFS) ReadFile(name string) ([]byte, error) {
+	if strings.Contains(name, p.panicOn) {
+		panic("corrupt " + name)
+	}
+	return p.WritableFS.ReadFile(name)
+}
+
+func TestPanicRollsBackChannel(t *testing.T) {
+	fsys := newMemFS()
+	for _, name := range []string{"a", "b", "c"} {
+		file := ".github/workflows/" + name + ".yml"
+		assert.NoError(t, fsys.WriteFile("master/keycloak/"+file, []byte("on: push\njobs:\n  build: {uses: ./.github/workflows/build.yml}\n"), 0644))
+		assert.NoError(t, fsys.WriteFile("master/patches/"+file, []byte("env:\n  A: 1\n"), 0644))
+	}
+
+	var out bytes.Buffer
+	m := newMerger(panickingFS{WritableFS: fsys, panicOn: "patches/.github/workflows/b.yml"}, defaultConfig(), &out)
+	m.jobs = 1
+	err := m.mergeVersions("master")
+	assert.ErrorContains(t, err, "panic: corrupt master/patches/.github/workflows/b.yml")
+	assert.Contains(t, out.String(), "Rolled back master: 2 generated files discarded")
+	for _, name := range []string{"a", "b", "c"} {
+		assert.False(t, fileExists(fsys, "master/build/.github/workflows/"+name+".yml"))
+	}
+	assert.False(t, fileExists(fsys, path.Join("master", DefaultOutputDir, ManifestFile)))
+}
+
+func TestVendoredActionsAreTransformed(t *testing.T) {
+	fsys := newMemFS()
+	assert.NoError(t, fsys.WriteFile("master/keycloak/.github/actions/build/action.yml", []byte(`name: Build
+description: Builds Keycloak
+runs:
+    using: composite
+    steps:
+        - uses: actions/checkout@v3
+        - uses: runforesight/foresight-test-kit-action@v1.2.1
+        - uses: webfactory/ssh-agent@v0.7.0
+          with:
+              ssh-private-key: ${{ secrets.SSH_KEY }}
+`), 0644))
+	// files next to the action are not metadata and stay with the upstream copy
+	assert.NoError(t, fsys.WriteFile("master/keycloak/.github/actions/build/conditions.yml", []byte("a: b\n"), 0644))
+	cfg := defaultConfig()
+	cfg.Transforms.Actions = &ActionPolicy{Deny: []DeniedAction{{Uses: "runforesight/*", Action: DenyRemove}}}
+	cfg.Transforms.Pin = &PinTransform{Pins: map[string]string{"actions/checkout@v3": "f43a0e5ff2bd294095638e18286ca9a3d1956744"}}
+
+	var out bytes.Buffer
+	assert.NoError(t, newMerger(fsys, cfg, &out).mergeVersions("master"))
+	assert.Contains(t, out.String(), "Vendoring upstream file master/keycloak/.github/actions/build/action.yml \n")
+	assert.Contains(t, out.String(), "master/build/.github/actions/build/action.yml: action not pinned: webfactory/ssh-agent@v0.7.0 (no pin)")
+	assert.False(t, fileExists(fsys, "master/build/.github/actions/build/conditions.yml"))
+
+	data, err := fsys.ReadFile("master/build/.github/actions/build/action.yml")
+	assert.NoError(t, err)
+	assert.True(t, bytes.HasPrefix(data, []byte("# generated by yaml-merge from master/keycloak/.github/actions/build/action.yml, do not edit\n")))
+	assert.Equal(t, `name: Build
+description: Builds Keycloak
+runs:
+    using: composite
+    steps:
+        - uses: actions/checkout@f43a0e5ff2bd294095638e18286ca9a3d1956744 # v3
+        - uses: webfactory/ssh-agent@v0.7.0
+          with:
+            ssh-private-key: ${{ secrets.SSH_KEY }}
+`, generatedBody(t, data))
+
+	manifest, err := loadManifest(fsys, Channel{Name: "master", Output: "master/build"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"master/build/.github/actions/build/action.yml"}, outputPaths(manifest))
+	assert.Equal(t, "", manifest.Outputs[0].Patch)
+
+	inventory, err := buildInventory(fsys, cfg.Layout, "master")
+	assert.NoError(t, err)
+	assert.Equal(t, []InventoryEntry{
+		{Kind: InventorySecret, Name: "SSH_KEY", Files: []string{".github/actions/build/action.yml"}},
+	}, inventory.Versions[0].Entries)
+}
+
+func TestMergeMasterPatch(t *testing.T) {
+	patch, err := os.ReadFile("../../../master/patches/.github/workflows/ci.yml")
+	assert.NoError(t, err)
+	fsys := newMemFS()
+	assert.NoError(t, fsys.WriteFile("master/patches/.github/workflows/ci.yml", patch, 0644))
+	assert.NoError(t, fsys.WriteFile("master/keycloak/.github/workflows/ci.yml", []byte(`name: Keycloak CI
+on:
+  push:
+    branches-ignore:
+      - main
+      - dependabot/**
+  pull_request:
+  workflow_dispatch:
+concurrency:
+  group: ci-${{ github.head_ref || github.run_id }}
+  cancel-in-progress: true
+jobs:
+  build:
+    name: Build
+    runs-on: ubuntu-latest
+    steps:
+      - uses: actions/checkout@v3
+`), 0644))
+
+	assert.NoError(t, newMerger(fsys, defaultConfig(), io.Discard).mergeVersions("master"))
+	data, err := fsys.ReadFile("master/build/.github/workflows/ci.yml")
+	assert.NoError(t, err)
+	var merged struct {
+		On       map[string]interface{} `yaml:"on"`
+		Defaults struct {
+			Run map[string]string `yaml:"run"`
+		} `yaml:"defaults"`
+	}
+	assert.NoError(t, yaml.Unmarshal([]byte(generatedBody(t, data)), &merged))
+	assert.Contains(t, merged.On, "workflow_dispatch")
+	assert.Contains(t, merged.On, "workflow_call")
+	assert.Equal(t, "./master/keycloak", merged.Defaults.Run["working-directory"])
 }
@@ -0,0 +1,191 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// RunnerSpec substitutes the runner of the jobs it matches. A job matches
+// when its id matches one of Jobs and its runs-on has one of Labels; an
+// empty list matches everything. Runners selected by ${{ matrix.<axis> }}
+// are substituted value by value in the matrix.
+type RunnerSpec struct {
+	WorkflowSelector `yaml:",inline"`
+	// Jobs lists globs of the job ids whose runner is substituted.
+	Jobs []string `yaml:"jobs"`
+	// Labels lists the upstream runner labels that are substituted, e.g.
+	// ubuntu-latest.
+	Labels []string `yaml:"labels"`
+	// RunsOn replaces the runs-on value: a label, a list of labels or a
+	// runner group mapping.
+	RunsOn yaml.Node `yaml:"runs-on"`
+}
+
+// validate checks the globs and the runner of the spec.
+func (s RunnerSpec) validate() error {
+	if err := s.WorkflowSelector.validate(); err != nil {
+		return err
+	}
+	if err := validateGlobs(s.Jobs); err != nil {
+		return fmt.Errorf("jobs: %w", err)
+	}
+	switch s.RunsOn.Kind {
+	case 0:
+		return errors.New("runs-on is required")
+	case yaml.ScalarNode:
+		if s.RunsOn.Value == "" {
+			return errors.New("runs-on is required")
+		}
+	case yaml.SequenceNode:
+		if len(s.RunsOn.Content) == 0 {
+			return errors.New("runs-on lists no labels")
+		}
+		for _, label := range s.RunsOn.Content {
+			if label.Kind != yaml.ScalarNode {
+				return errors.New("runs-on must list labels")
+			}
+		}
+	case yaml.MappingNode:
+		if mappingValue(&s.RunsOn, "group") == nil && mappingValue(&s.RunsOn, "labels") == nil {
+			return errors.New("runs-on must have a group or labels")
+		}
+	default:
+		return errors.New("runs-on must be a label, a list of labels or a runner group")
+	}
+	return nil
+}
+
+// matches reports whether the spec substitutes a runner with labels of a
+// job of a workflow file of channel.
+func (s RunnerSpec) matches(channel Channel, workflow, job string, labels []string) bool {
+	if !s.WorkflowSelector.matches(channel, workflow) {
+		return false
+	}
+	if len(s.Jobs) > 0 && matchingGlob(s.Jobs, job) == "" {
+		return false
+	}
+	if len(s.Labels) == 0 {
+		return true
+	}
+	for _, label := range labels {
+		for _, want := range s.Labels {
+			if label == want {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// runnerTransform substitutes the runners of jobs with the first spec
+// matching each of them.
+type runnerTransform []RunnerSpec
+
+// matrixRunnerPattern matches a runs-on taken from a matrix axis.
+var matrixRunnerPattern = regexp.MustCompile(`^\$\{\{\s*matrix\.([A-Za-z_][A-Za-z0-9_-]*)\s*\}\}$`)
+
+// apply substitutes the runs-on of every job of a workflow.
+func (specs runnerTransform) apply(ctx transformContext, doc *yaml.Node) error {
+	root := documentRoot(doc)
+	if root == nil || !ctx.isWorkflow() {
+		return nil
+	}
+	forEachJob(root, func(id string, job *yaml.Node) {
+		runsOn := mappingValue(job, "runs-on")
+		if runsOn == nil {
+			return
+		}
+		if runsOn.Kind == yaml.ScalarNode && strings.Contains(runsOn.Value, "${{") {
+			m := matrixRunnerPattern.FindStringSubmatch(runsOn.Value)
+			if m == nil {
+				ctx.report("cannot substitute the runner of job %s: runs-on is %s", id, runsOn.Value)
+				return
+			}
+			specs.substituteMatrix(ctx, id, job, m[1])
+			return
+		}
+		if runner := specs.runner(ctx, id, runsOn); runner != nil {
+			setMappingValue(job, "runs-on", cloneNode(runner))
+		}
+	})
+	return nil
+}
+
+// runner returns the runner replacing runsOn in job id, or nil when no
+// spec matches it.
+func (specs runnerTransform) runner(ctx transformContext, id string, runsOn *yaml.Node) *yaml.Node {
+	labels := runnerLabels(runsOn)
+	for i := range specs {
+		if specs[i].matches(ctx.channel, ctx.path, id, labels) {
+			return &specs[i].RunsOn
+		}
+	}
+	return nil
+}
+
+// substituteMatrix substitutes the runners listed by a matrix axis of job,
+// in the axis values and in the include entries setting the axis.
+func (specs runnerTransform) substituteMatrix(ctx transformContext, id string, job *yaml.Node, axis string) {
+	matrix := mappingValue(mappingValue(job, "strategy"), "matrix")
+	if matrix == nil || matrix.Kind != yaml.MappingNode {
+		ctx.report("cannot substitute the runner of job %s: its matrix is not a mapping", id)
+		return
+	}
+	substitute := func(value *yaml.Node) {
+		if value.Kind == yaml.ScalarNode && strings.Contains(value.Value, "${{") {
+			ctx.report("cannot substitute the runner %s of job %s", value.Value, id)
+			return
+		}
+		if runner := specs.runner(ctx, id, value); runner != nil {
+			flow := cloneNode(runner)
+			rewriteStyle(flow, yaml.FlowStyle)
+			*value = *flow
+		}
+	}
+	values := mappingValue(matrix, axis)
+	switch {
+	case values == nil:
+	case values.Kind == yaml.SequenceNode:
+		for _, value := range values.Content {
+			substitute(value)
+		}
+	default:
+		substitute(values)
+	}
+	if include := mappingValue(matrix, "include"); include != nil && include.Kind == yaml.SequenceNode {
+		for _, entry := range include.Content {
+			if value := mappingValue(entry, axis); value != nil {
+				substitute(value)
+			}
+		}
+	}
+}
+
+// runnerLabels returns the labels of a runs-on value: the label, the
+// listed labels, or the group and labels of a runner group.
+func runnerLabels(runsOn *yaml.Node) []string {
+	switch runsOn.Kind {
+	case yaml.ScalarNode:
+		return []string{runsOn.Value}
+	case yaml.SequenceNode:
+		var labels []string
+		for _, label := range runsOn.Content {
+			labels = append(labels, label.Value)
+		}
+		return labels
+	case yaml.MappingNode:
+		var labels []string
+		if group := mappingValue(runsOn, "group"); group != nil {
+			labels = append(labels, group.Value)
+		}
+		if list := mappingValue(runsOn, "labels"); list != nil {
+			labels = append(labels, runnerLabels(list)...)
+		}
+		return labels
+	}
+	return nil
+}
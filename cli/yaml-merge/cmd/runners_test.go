@@ -0,0 +1,95 @@
+package cmd
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v3"
+)
+
+func TestRunnerTransform(t *testing.T) {
+	var doc yaml.Node
+	assert.NoError(t, yaml.Unmarshal([]byte(`name: CI
+jobs:
+    build:
+        runs-on: ubuntu-latest
+    unit-tests:
+        runs-on: [ubuntu-latest]
+    windows:
+        runs-on: windows-latest
+    docs:
+        runs-on: ubuntu-latest
+    tests:
+        runs-on: ${{ matrix.os }}
+        strategy:
+            matrix:
+                os: [ubuntu-latest, windows-latest]
+                include:
+                    - os: ubuntu-22.04
+                      java: 11
+    dynamic:
+        runs-on: ${{ inputs.runner }}
+    reusable:
+        uses: ./.github/workflows/other.yml
+`), &doc))
+
+	var config struct {
+		Runners []RunnerSpec `yaml:"runners"`
+	}
+	assert.NoError(t, yaml.Unmarshal([]byte(`runners:
+    - jobs: [docs]
+      runs-on: ubuntu-latest
+    - labels: [ubuntu-latest, ubuntu-22.04]
+      runs-on: [self-hosted, linux, x64, large]
+    - match: v{major}
+      runs-on: other
+`), &config))
+	for _, spec := range config.Runners {
+		assert.NoError(t, spec.validate())
+	}
+
+	var out bytes.Buffer
+	ctx := transformContext{channel: Channel{Name: "master"}, path: "master/build/.github/workflows/ci.yml", out: &out}
+	assert.NoError(t, runnerTransform(config.Runners).apply(ctx, &doc))
+
+	data, err := yaml.Marshal(&doc)
+	assert.NoError(t, err)
+	assert.Equal(t, `name: CI
+jobs:
+    build:
+        runs-on: [self-hosted, linux, x64, large]
+    unit-tests:
+        runs-on: [self-hosted, linux, x64, large]
+    windows:
+        runs-on: windows-latest
+    docs:
+        runs-on: ubuntu-latest
+    tests:
+        runs-on: ${{ matrix.os }}
+        strategy:
+            matrix:
+                os: [[self-hosted, linux, x64, large], windows-latest]
+                include:
+                    - os: [self-hosted, linux, x64, large]
+                      java: 11
+    dynamic:
+        runs-on: ${{ inputs.runner }}
+    reusable:
+        uses: ./.github/workflows/other.yml
+`, string(data))
+	assert.Equal(t, "master/build/.github/workflows/ci.yml: cannot substitute the runner of job dynamic: runs-on is ${{ inputs.runner }} \n", out.String())
+}
+
+func TestRunnerLabels(t *testing.T) {
+	var runsOn yaml.Node
+	assert.NoError(t, yaml.Unmarshal([]byte("{group: large-runners, labels: [linux, x64]}"), &runsOn))
+	assert.Equal(t, []string{"large-runners", "linux", "x64"}, runnerLabels(runsOn.Content[0]))
+}
+
+func TestRunnerSpecValidation(t *testing.T) {
+	assert.NoError(t, RunnerSpec{RunsOn: *stringNode("self-hosted")}.validate())
+	assert.EqualError(t, RunnerSpec{}.validate(), "runs-on is required")
+	assert.Error(t, RunnerSpec{Jobs: []string{"["}, RunsOn: *stringNode("self-hosted")}.validate())
+	assert.EqualError(t, RunnerSpec{RunsOn: yaml.Node{Kind: yaml.SequenceNode}}.validate(), "runs-on lists no labels")
+}
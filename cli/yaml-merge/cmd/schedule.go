@@ -0,0 +1,268 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ScheduleSpec replaces the schedule triggers of the workflows it matches
+// with centrally defined cron entries. Each version's entries are shifted by
+// a fixed number of minutes derived from its version, so versions sharing a
+// schedule do not all start at once on shared runners.
+type ScheduleSpec struct {
+	WorkflowSelector `yaml:",inline"`
+	// Cron lists the schedules in cron syntax. Without any, the schedule
+	// trigger is removed.
+	Cron []string `yaml:"cron"`
+	// Stagger is the number of minutes, at most 60, over which the versions
+	// are spread. Zero keeps the schedules as they are.
+	Stagger int `yaml:"stagger"`
+}
+
+// validate checks the cron entries and the stagger window.
+func (s ScheduleSpec) validate() error {
+	if err := s.WorkflowSelector.validate(); err != nil {
+		return err
+	}
+	if s.Stagger < 0 || s.Stagger > 60 {
+		return errors.New("stagger must be between 0 and 60 minutes")
+	}
+	for _, cron := range s.Cron {
+		if err := validateCron(cron); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// scheduleTransform sets the schedules of workflows from the first spec
+// matching each of them.
+type scheduleTransform []ScheduleSpec
+
+// apply replaces the on.schedule section of a workflow.
+func (specs scheduleTransform) apply(ctx transformContext, doc *yaml.Node) error {
+	root := documentRoot(doc)
+	if root == nil || !ctx.isWorkflow() {
+		return nil
+	}
+	for _, spec := range specs {
+		if spec.matches(ctx.channel, ctx.path) {
+			return spec.apply(ctx, root)
+		}
+	}
+	return nil
+}
+
+// apply replaces the schedule of root with the spec's entries, staggered
+// for the channel.
+func (s ScheduleSpec) apply(ctx transformContext, root *yaml.Node) error {
+	on := eventMapping(root)
+	if len(s.Cron) == 0 {
+		removeMappingKey(on, "schedule")
+		return nil
+	}
+	offset := staggerOffset(ctx.channel, s.Stagger)
+	schedule := &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq"}
+	for _, cron := range s.Cron {
+		shifted, err := shiftCron(cron, offset)
+		if err != nil {
+			return fmt.Errorf("staggering %q by %d minutes: %w", cron, offset, err)
+		}
+		if err := validateCron(shifted); err != nil {
+			return err
+		}
+		entry := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+		setMappingValue(entry, "cron", stringNode(shifted))
+		schedule.Content = append(schedule.Content, entry)
+	}
+	setMappingValue(on, "schedule", schedule)
+	return nil
+}
+
+// staggerOffset returns the minutes the schedules of channel are shifted
+// by. Major trees get an even slot from their major and minor trees an odd
+// one from their major and minor, so a major tree and its .0 minor tree
+// differ; master gets slot 0 and other channels one from a hash of their
+// name. Slots are spread over the window by the golden ratio, so
+// neighbouring versions land far apart and a version keeps its offset as
+// others come and go.
+func staggerOffset(channel Channel, stagger int) int {
+	if stagger <= 0 {
+		return 0
+	}
+	var slot uint64
+	switch {
+	case channel.Major != "":
+		major, _ := strconv.ParseUint(channel.Major, 10, 32)
+		slot = 2 * major
+		if channel.Minor != "" {
+			minor, _ := strconv.ParseUint(channel.Minor, 10, 32)
+			slot = 2*versionSlot(major, minor) + 1
+		}
+	case channel.Name != "master":
+		h := fnv.New32a()
+		h.Write([]byte(channel.Name))
+		slot = uint64(h.Sum32())
+	}
+	const golden = 0.6180339887498949
+	_, frac := math.Modf(float64(slot) * golden)
+	return int(frac * float64(stagger))
+}
+
+// versionSlot pairs a major and a minor into one number that no other pair
+// maps to.
+func versionSlot(major, minor uint64) uint64 {
+	return (major+minor)*(major+minor+1)/2 + minor
+}
+
+// cronFields are the fields of a cron entry with their ranges.
+var cronFields = []struct {
+	name     string
+	min, max int
+}{
+	{"minute", 0, 59},
+	{"hour", 0, 23},
+	{"day of month", 1, 31},
+	{"month", 1, 12},
+	{"day of week", 0, 6},
+}
+
+// validateCron checks that cron is a five-field entry whose fields are
+// lists of *, values, ranges and steps within the field's range.
+func validateCron(cron string) error {
+	fields := strings.Fields(cron)
+	if len(fields) != len(cronFields) {
+		return fmt.Errorf("cron %q: want %d fields, got %d", cron, len(cronFields), len(fields))
+	}
+	for i, field := range fields {
+		spec := cronFields[i]
+		for _, item := range strings.Split(field, ",") {
+			if err := validateCronItem(item, spec.min, spec.max); err != nil {
+				return fmt.Errorf("cron %q: %s: %w", cron, spec.name, err)
+			}
+		}
+	}
+	return nil
+}
+
+func validateCronItem(item string, min, max int) error {
+	values, step, hasStep := strings.Cut(item, "/")
+	if hasStep {
+		n, err := strconv.Atoi(step)
+		if err != nil || n < 1 {
+			return fmt.Errorf("invalid step %q", step)
+		}
+	}
+	if values == "*" {
+		return nil
+	}
+	low, high, isRange := strings.Cut(values, "-")
+	lowValue, err := cronValue(low, min, max)
+	if err != nil {
+		return err
+	}
+	if !isRange {
+		return nil
+	}
+	highValue, err := cronValue(high, min, max)
+	if err != nil {
+		return err
+	}
+	if highValue < lowValue {
+		return fmt.Errorf("invalid range %q", values)
+	}
+	return nil
+}
+
+func cronValue(s string, min, max int) (int, error) {
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value %q", s)
+	}
+	if n < min || n > max {
+		return 0, fmt.Errorf("%d is out of range %d-%d", n, min, max)
+	}
+	return n, nil
+}
+
+// shiftCron moves a cron entry offset minutes later. The minute field must
+// be a list of values or a */step; minutes pushed past the hour carry into
+// the hour field, which then has to be a list of values or *.
+func shiftCron(cron string, offset int) (string, error) {
+	fields := strings.Fields(cron)
+	if offset == 0 || len(fields) != len(cronFields) {
+		return cron, nil
+	}
+	if step, ok := strings.CutPrefix(fields[0], "*/"); ok {
+		n, err := strconv.Atoi(step)
+		if err != nil || n < 1 {
+			return "", fmt.Errorf("invalid step %q", step)
+		}
+		fields[0] = fmt.Sprintf("%d-59/%d", offset%n, n)
+		return strings.Join(fields, " "), nil
+	}
+
+	minutes, err := cronList(fields[0])
+	if err != nil {
+		return "", errors.New("minute must be a list of values")
+	}
+	carried := 0
+	for i, minute := range minutes {
+		minutes[i] = minute + offset
+		if minutes[i] >= 60 {
+			minutes[i] -= 60
+			carried++
+		}
+	}
+	if carried > 0 {
+		if carried < len(minutes) {
+			return "", errors.New("minutes would move into different hours")
+		}
+		if fields[1] != "*" {
+			hours, err := cronList(fields[1])
+			if err != nil {
+				return "", errors.New("hour must be a list of values")
+			}
+			if fields[2] != "*" || fields[4] != "*" {
+				for _, hour := range hours {
+					if hour == 23 {
+						return "", errors.New("would move into the next day")
+					}
+				}
+			}
+			for i := range hours {
+				hours[i] = (hours[i] + 1) % 24
+			}
+			fields[1] = joinCronList(hours)
+		}
+	}
+	fields[0] = joinCronList(minutes)
+	return strings.Join(fields, " "), nil
+}
+
+// cronList parses a comma-separated list of plain values.
+func cronList(field string) ([]int, error) {
+	var values []int
+	for _, item := range strings.Split(field, ",") {
+		n, err := strconv.Atoi(item)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, n)
+	}
+	return values, nil
+}
+
+func joinCronList(values []int) string {
+	items := make([]string, len(values))
+	for i, n := range values {
+		items[i] = strconv.Itoa(n)
+	}
+	return strings.Join(items, ",")
+}
@@ -0,0 +1,98 @@
+package cmd
+
+import (
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScheduleTransform(t *testing.T) {
+	fsys := newMemFS()
+	for _, root := range []string{"master", "releases/v21/latest"} {
+		assert.NoError(t, fsys.WriteFile(root+"/keycloak/.github/workflows/ci.yml", []byte("name: CI\non:\n    schedule:\n        - cron: '0 0 * * *'\n"), 0644))
+		assert.NoError(t, fsys.WriteFile(root+"/keycloak/.github/workflows/docs.yml", []byte("name: Docs\non:\n    schedule:\n        - cron: '0 0 * * *'\n"), 0644))
+		assert.NoError(t, fsys.WriteFile(root+"/patches/.github/workflows/ci.yml", []byte("env: {A: 1}\n"), 0644))
+		assert.NoError(t, fsys.WriteFile(root+"/patches/.github/workflows/docs.yml", []byte("env: {A: 1}\n"), 0644))
+	}
+	assert.NoError(t, fsys.WriteFile(DefaultConfigFile, []byte(`transforms:
+    schedules:
+        - workflows: [ci.yml]
+          cron: ['0 20,23,2,5 * * *', '50 12 * * 1-5']
+          stagger: 30
+        - {}
+`), 0644))
+	cfg, err := loadConfig(fsys, DefaultConfigFile, true)
+	assert.NoError(t, err)
+	assert.NoError(t, newMerger(fsys, cfg, io.Discard).mergeVersions("all"))
+
+	data, err := fsys.ReadFile("master/build/.github/workflows/ci.yml")
+	assert.NoError(t, err)
+	assert.Contains(t, generatedBody(t, data), "    schedule:\n        - cron: 0 20,23,2,5 * * *\n        - cron: 50 12 * * 1-5\n")
+
+	// v21 starts 28 minutes later
+	data, err = fsys.ReadFile("releases/v21/latest/build/.github/workflows/ci.yml")
+	assert.NoError(t, err)
+	assert.Contains(t, generatedBody(t, data), "    schedule:\n        - cron: 28 20,23,2,5 * * *\n        - cron: 18 13 * * 1-5\n")
+
+	// a spec without cron entries drops the schedule
+	data, err = fsys.ReadFile("releases/v21/latest/build/.github/workflows/docs.yml")
+	assert.NoError(t, err)
+	assert.Equal(t, "name: Docs\non: {}\nenv: {A: 1}\n", generatedBody(t, data))
+}
+
+func TestStaggerOffset(t *testing.T) {
+	offsets := map[int]string{}
+	for _, channel := range []Channel{
+		{Name: "master"},
+		{Name: "nightly"},
+		{Name: "v19", Major: "19"},
+		{Name: "v20", Major: "20"},
+		{Name: "v21", Major: "21"},
+		{Name: "v22", Major: "22"},
+	} {
+		offset := staggerOffset(channel, 30)
+		assert.NotContains(t, offsets, offset, channel.Name)
+		assert.Less(t, offset, 30)
+		offsets[offset] = channel.Name
+	}
+	assert.Equal(t, 0, staggerOffset(Channel{Name: "master"}, 30))
+	// a major tree and its .0 minor tree are different versions
+	for _, major := range []string{"20", "21"} {
+		assert.NotEqual(t,
+			staggerOffset(Channel{Name: "v" + major, Major: major}, 30),
+			staggerOffset(Channel{Name: "v" + major + ".0", Major: major, Minor: "0"}, 30), major)
+	}
+	assert.Equal(t, 0, staggerOffset(Channel{Name: "v21", Major: "21"}, 0))
+}
+
+func TestShiftCron(t *testing.T) {
+	for _, tc := range []struct{ cron, want, err string }{
+		{cron: "0 20,23,2,5 * * *", want: "10 20,23,2,5 * * *"},
+		{cron: "55 23 * * *", want: "5 0 * * *"},
+		{cron: "55 * * * *", want: "5 * * * *"},
+		{cron: "*/15 * * * *", want: "10-59/15 * * * *"},
+		{cron: "55 23 * * 1", err: "would move into the next day"},
+		{cron: "0,55 * * * *", err: "minutes would move into different hours"},
+		{cron: "0-5 * * * *", err: "minute must be a list of values"},
+	} {
+		got, err := shiftCron(tc.cron, 10)
+		if tc.err != "" {
+			assert.EqualError(t, err, tc.err, tc.cron)
+			continue
+		}
+		assert.NoError(t, err, tc.cron)
+		assert.Equal(t, tc.want, got, tc.cron)
+	}
+}
+
+func TestValidateCron(t *testing.T) {
+	assert.NoError(t, validateCron("0 20,23,2,5 * * *"))
+	assert.NoError(t, validateCron("*/5 9-17 1,15 */2 1-5"))
+	assert.EqualError(t, validateCron("0 0 * *"), `cron "0 0 * *": want 5 fields, got 4`)
+	assert.EqualError(t, validateCron("60 0 * * *"), `cron "60 0 * * *": minute: 60 is out of range 0-59`)
+	assert.EqualError(t, validateCron("0 5-2 * * *"), `cron "0 5-2 * * *": hour: invalid range "5-2"`)
+	assert.EqualError(t, validateCron("0 0 * JAN *"), `cron "0 0 * JAN *": month: invalid value "JAN"`)
+	assert.EqualError(t, validateCron("0 0 * * */0"), `cron "0 0 * * */0": day of week: invalid step "0"`)
+	assert.EqualError(t, ScheduleSpec{Stagger: 90}.validate(), "stagger must be between 0 and 60 minutes")
+}
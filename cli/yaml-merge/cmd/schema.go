@@ -0,0 +1,400 @@
+package cmd
+
+import (
+	"embed"
+	"fmt"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// schemaFiles holds the schemas merged workflows and actions are validated
+// against.
+//
+//go:embed schema/*.yaml
+var schemaFiles embed.FS
+
+var (
+	workflowSchema = mustLoadSchema("schema/workflow.yaml")
+	actionSchema   = mustLoadSchema("schema/action.yaml")
+)
+
+// schema describes the nodes allowed at one place of a YAML file. It is a
+// small subset of JSON Schema, written in YAML:
+//
+//   - type is a node type or a list of them: string, boolean, number, null,
+//     mapping or sequence; any when empty. A string accepts any non-null
+//     scalar, as GitHub reads numbers and booleans as strings there. A
+//     string holding a ${{ }} expression is accepted for every type, as it
+//     is only known at run time.
+//   - enum lists the values a scalar may have.
+//   - properties are the schemas of the keys of a mapping. Keys that are not
+//     properties must match values, and are rejected when there are
+//     properties but no values.
+//   - required lists the keys a mapping must have.
+//   - minProperties is the least number of keys a mapping must have.
+//   - items is the schema of the entries of a sequence.
+//   - variants are alternative schemas for a mapping; the first whose when
+//     keys are all present, with one of the listed values if any, applies.
+//     A mapping none applies to is rejected.
+//   - ref names a schema of the definitions of the file instead.
+type schema struct {
+	Type          yaml.Node           `yaml:"type"`
+	Enum          []string            `yaml:"enum"`
+	Properties    map[string]*schema  `yaml:"properties"`
+	Values        *schema             `yaml:"values"`
+	Required      []string            `yaml:"required"`
+	MinProperties int                 `yaml:"minProperties"`
+	Items         *schema             `yaml:"items"`
+	Variants      []*schema           `yaml:"variants"`
+	When          map[string][]string `yaml:"when"`
+	Ref           string              `yaml:"ref"`
+	Definitions   map[string]*schema  `yaml:"definitions"`
+
+	// types is Type as a list.
+	types []string
+}
+
+// schemaTypes are the node types schemas know.
+var schemaTypes = map[string]bool{"string": true, "boolean": true, "number": true, "null": true, "mapping": true, "sequence": true}
+
+// mustLoadSchema reads an embedded schema and resolves its references. The
+// schemas are part of the binary, so an invalid one is a bug.
+func mustLoadSchema(name string) *schema {
+	data, err := schemaFiles.ReadFile(name)
+	if err != nil {
+		panic(err)
+	}
+	var root schema
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		panic(fmt.Sprintf("%s: %v", name, err))
+	}
+	done := map[*schema]bool{}
+	for _, definition := range root.Definitions {
+		if err := definition.resolve(root.Definitions, done); err != nil {
+			panic(fmt.Sprintf("%s: %v", name, err))
+		}
+	}
+	if err := root.resolve(root.Definitions, done); err != nil {
+		panic(fmt.Sprintf("%s: %v", name, err))
+	}
+	return &root
+}
+
+// resolve replaces the references below s by the definitions they name and
+// reads the types of every schema.
+func (s *schema) resolve(definitions map[string]*schema, done map[*schema]bool) error {
+	if done[s] {
+		return nil
+	}
+	done[s] = true
+	switch s.Type.Kind {
+	case yaml.ScalarNode:
+		s.types = []string{s.Type.Value}
+	case yaml.SequenceNode:
+		for _, t := range s.Type.Content {
+			s.types = append(s.types, t.Value)
+		}
+	}
+	for _, t := range s.types {
+		if !schemaTypes[t] {
+			return fmt.Errorf("unknown type %s", t)
+		}
+	}
+	child := func(c *schema) (*schema, error) {
+		if c == nil {
+			return nil, nil
+		}
+		if c.Ref != "" {
+			if definitions[c.Ref] == nil {
+				return nil, fmt.Errorf("undefined schema %s", c.Ref)
+			}
+			c = definitions[c.Ref]
+		}
+		return c, c.resolve(definitions, done)
+	}
+	var err error
+	if s.Values, err = child(s.Values); err != nil {
+		return err
+	}
+	if s.Items, err = child(s.Items); err != nil {
+		return err
+	}
+	for key := range s.Properties {
+		if s.Properties[key], err = child(s.Properties[key]); err != nil {
+			return err
+		}
+	}
+	for i := range s.Variants {
+		if s.Variants[i], err = child(s.Variants[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// schemaViolation is a node that does not match its schema.
+type schemaViolation struct {
+	// offending is the node that does not match.
+	offending *yaml.Node
+	// node is the offending node, or the closest node above it that has a
+	// position when it was created by a transform.
+	node *yaml.Node
+	// at is the YAML path of the offending node.
+	at      string
+	message string
+}
+
+// validateSchema returns the nodes of a document that do not match s.
+func validateSchema(doc *yaml.Node, s *schema) []schemaViolation {
+	if doc.Kind == yaml.DocumentNode {
+		if len(doc.Content) == 0 {
+			return nil
+		}
+		doc = doc.Content[0]
+	}
+	var violations []schemaViolation
+	s.validate(doc, "", doc, &violations)
+	return violations
+}
+
+// validate checks node, found at the YAML path at, against s. located is
+// the closest node with a position.
+func (s *schema) validate(node *yaml.Node, at string, located *yaml.Node, violations *[]schemaViolation) {
+	for node.Kind == yaml.AliasNode && node.Alias != nil {
+		node = node.Alias
+	}
+	if node.Line > 0 {
+		located = node
+	}
+	violate := func(format string, args ...interface{}) {
+		*violations = append(*violations, schemaViolation{offending: node, node: located, at: at, message: fmt.Sprintf(format, args...)})
+	}
+	kind := nodeType(node)
+	if kind == "string" && strings.Contains(node.Value, "${{") {
+		return
+	}
+	if !s.accepts(kind) {
+		violate("expected %s, got %s", strings.Join(s.types, " or "), kind)
+		return
+	}
+	switch node.Kind {
+	case yaml.ScalarNode:
+		if len(s.Enum) > 0 && !containsString(s.Enum, node.Value) {
+			violate("%s is not one of %s", node.Value, strings.Join(s.Enum, ", "))
+		}
+	case yaml.SequenceNode:
+		if s.Items != nil {
+			for i, item := range node.Content {
+				s.Items.validate(item, fmt.Sprintf("%s[%d]", at, i), located, violations)
+			}
+		}
+	case yaml.MappingNode:
+		if len(s.Variants) > 0 {
+			if variant := s.variant(node); variant != nil {
+				variant.validate(node, at, located, violations)
+			} else {
+				s.noVariant(node, at, located, violations)
+			}
+			return
+		}
+		for _, key := range s.Required {
+			if mappingValue(node, key) == nil {
+				violate("missing required key %s", key)
+			}
+		}
+		if keys := len(node.Content) / 2; keys < s.MinProperties {
+			violate("must have at least %d %s", s.MinProperties, pluralKeys(s.MinProperties))
+		}
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			child := s.Properties[key.Value]
+			if child == nil {
+				child = s.Values
+			}
+			if child == nil {
+				if s.Properties != nil {
+					keyLocated := located
+					if key.Line > 0 {
+						keyLocated = key
+					}
+					*violations = append(*violations, schemaViolation{offending: key, node: keyLocated, at: joinYAMLPath(at, key.Value), message: "unknown key"})
+				}
+				continue
+			}
+			child.validate(value, joinYAMLPath(at, key.Value), located, violations)
+		}
+	}
+}
+
+// accepts reports whether s allows nodes of a type.
+func (s *schema) accepts(kind string) bool {
+	if len(s.types) == 0 {
+		return true
+	}
+	for _, t := range s.types {
+		if t == kind || t == "string" && (kind == "boolean" || kind == "number") {
+			return true
+		}
+	}
+	return false
+}
+
+// variant returns the first variant of s that applies to a mapping.
+func (s *schema) variant(node *yaml.Node) *schema {
+	for _, variant := range s.Variants {
+		applies := true
+		for key, values := range variant.When {
+			value := mappingValue(node, key)
+			if value == nil || len(values) > 0 && !containsString(values, value.Value) {
+				applies = false
+			}
+		}
+		if applies {
+			return variant
+		}
+	}
+	return nil
+}
+
+// noVariant reports why no variant of s applies to a mapping: a variant
+// key is missing, or, when the variants differ by the value of one key,
+// that value is not one of theirs.
+func (s *schema) noVariant(node *yaml.Node, at string, located *yaml.Node, violations *[]schemaViolation) {
+	var keys, values []string
+	for _, variant := range s.Variants {
+		for _, key := range sortedKeys(variant.When) {
+			keys = appendUnique(keys, key)
+			values = append(values, variant.When[key]...)
+		}
+	}
+	violation := schemaViolation{offending: node, node: located, at: at, message: "must have " + strings.Join(keys, " or ")}
+	if len(keys) == 1 && len(values) > 0 {
+		violation.message = "missing required key " + keys[0]
+		if value := mappingValue(node, keys[0]); value != nil {
+			violation.at = joinYAMLPath(at, keys[0])
+			violation.message = fmt.Sprintf("%s is not one of %s", value.Value, strings.Join(values, ", "))
+			violation.offending = value
+			if value.Line > 0 {
+				violation.node = value
+			}
+		}
+	}
+	*violations = append(*violations, violation)
+}
+
+// pluralKeys returns "key" or "keys" to go with n.
+func pluralKeys(n int) string {
+	if n == 1 {
+		return "key"
+	}
+	return "keys"
+}
+
+// nodeType returns the schema type of a node.
+func nodeType(node *yaml.Node) string {
+	switch node.Kind {
+	case yaml.MappingNode:
+		return "mapping"
+	case yaml.SequenceNode:
+		return "sequence"
+	}
+	switch node.ShortTag() {
+	case "!!null":
+		return "null"
+	case "!!bool":
+		return "boolean"
+	case "!!int", "!!float":
+		return "number"
+	}
+	return "string"
+}
+
+// containsString reports whether values contains value.
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// fileSchema returns the schema of the file a transform context describes,
+// or nil when it is neither a workflow nor an action.
+func fileSchema(ctx transformContext) *schema {
+	switch {
+	case ctx.isWorkflow():
+		return workflowSchema
+	case ctx.isAction():
+		return actionSchema
+	}
+	return nil
+}
+
+// nodeOrigin tells which input file the nodes of a merged document come
+// from. Merging moves the nodes of the patch into the upstream document;
+// the nodes neither file holds were created by a transform.
+type nodeOrigin struct {
+	patch, upstream string
+	patchNodes      map[*yaml.Node]bool
+	upstreamNodes   map[*yaml.Node]bool
+}
+
+// newNodeOrigin records the nodes of the parsed patch and upstream file,
+// before they are merged.
+func newNodeOrigin(patchFile, upstreamFile string, patch, upstream *yaml.Node) nodeOrigin {
+	return nodeOrigin{
+		patch:         patchFile,
+		upstream:      upstreamFile,
+		patchNodes:    nodeSet(patch),
+		upstreamNodes: nodeSet(upstream),
+	}
+}
+
+// nodeSet returns node and every node below it.
+func nodeSet(node *yaml.Node) map[*yaml.Node]bool {
+	nodes := map[*yaml.Node]bool{}
+	var walk func(node *yaml.Node)
+	walk = func(node *yaml.Node) {
+		nodes[node] = true
+		for _, child := range node.Content {
+			walk(child)
+		}
+	}
+	walk(node)
+	return nodes
+}
+
+// describe formats a violation as file:line:column: path: message, with
+// the position in the file the offending node comes from. A node a
+// transform created has no such position.
+func (o nodeOrigin) describe(v schemaViolation) string {
+	at := v.at
+	if at == "" {
+		at = "<root>"
+	}
+	file := o.upstream
+	switch {
+	case o.patchNodes[v.offending]:
+		file = o.patch
+	case !o.upstreamNodes[v.offending]:
+		return fmt.Sprintf("%s: %s (set by a transform)", at, v.message)
+	}
+	return fmt.Sprintf("%s:%d:%d: %s: %s", file, v.node.Line, v.node.Column, at, v.message)
+}
+
+// violationMessages returns the violations of doc against s as path: message,
+// so that violations of two versions of a document can be matched.
+func violationMessages(doc *yaml.Node, s *schema) map[string]bool {
+	messages := map[string]bool{}
+	for _, v := range validateSchema(doc, s) {
+		messages[v.key()] = true
+	}
+	return messages
+}
+
+// key identifies a violation by its path and message.
+func (v schemaViolation) key() string {
+	return v.at + ": " + v.message
+}
@@ -0,0 +1,133 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v3"
+)
+
+func TestValidateSchema(t *testing.T) {
+	validate := func(s *schema, data string) []string {
+		var doc yaml.Node
+		assert.NoError(t, yaml.Unmarshal([]byte(data), &doc))
+		var found []string
+		for _, v := range validateSchema(&doc, s) {
+			found = append(found, fmt.Sprintf("%d:%d: %s: %s", v.node.Line, v.node.Column, v.at, v.message))
+		}
+		return found
+	}
+
+	assert.Equal(t, []string{
+		"2:12: on.push.branch: unknown key",
+		"3:5: on.pull-request: unknown key",
+		"4:15: permissions.content: unknown key",
+		"4:38: permissions.issues: admin is not one of read, write, none",
+		"7:9: jobs.build: must have uses or runs-on",
+		"10:26: jobs.test.timeout-minutes: expected number, got string",
+		"11:16: jobs.test.steps: expected sequence, got mapping",
+		"13:9: jobs.call.runs-on: unknown key",
+	}, validate(workflowSchema, `on:
+    push: {branch: main}
+    pull-request: {}
+permissions: {content: read, issues: admin}
+jobs:
+    build:
+        steps: []
+    test:
+        runs-on: ${{ inputs.runner }}
+        timeout-minutes: ten
+        steps: {run: test}
+    call:
+        runs-on: ubuntu-latest
+        uses: ./.github/workflows/shared.yml
+        secrets: inherit
+        with: {a: 1, b: "${{ matrix.b }}"}
+`))
+	assert.Equal(t, []string{
+		"2:7: jobs: must have at least 1 key",
+	}, validate(workflowSchema, "on: push\njobs: {}\n"))
+
+	assert.Equal(t, []string{
+		"1:1: : missing required key description",
+		"3:12: runs.using: node10 is not one of composite, node12, node16, node20, docker",
+	}, validate(actionSchema, `name: Build
+runs:
+    using: node10
+`))
+	assert.Equal(t, []string{
+		"6:11: runs.steps[0]: missing required key shell",
+	}, validate(actionSchema, `name: Build
+description: Builds Keycloak
+runs:
+    using: composite
+    steps:
+        - run: mvn install
+        - uses: actions/checkout@v3
+          continue-on-error: ${{ inputs.optional }}
+`))
+}
+
+func TestSchemaViolationsPointAtTheirSource(t *testing.T) {
+	fsys := newMemFS()
+	assert.NoError(t, fsys.WriteFile("master/keycloak/.github/workflows/ci.yml", []byte(`name: CI
+on:
+    workflow_call:
+        inputs:
+            version: {type: string}
+jobs:
+    build:
+        steps:
+            - run: ./mvnw install
+`), 0644))
+	assert.NoError(t, fsys.WriteFile("master/patches/.github/workflows/ci.yml", []byte(`on:
+    workflow_call:
+        branches: [main]
+        inputs:
+            database: {type: choice, options: [postgres]}
+`), 0644))
+
+	var out bytes.Buffer
+	err := newMerger(fsys, defaultConfig(), &out).mergeVersions("master")
+	assert.EqualError(t, err, `Schema violations in "master/build/.github/workflows/ci.yml":
+  master/patches/.github/workflows/ci.yml:5:30: on.workflow_call.inputs.database.type: choice is not one of boolean, number, string
+  master/patches/.github/workflows/ci.yml:5:38: on.workflow_call.inputs.database.options: unknown key
+  master/patches/.github/workflows/ci.yml:3:9: on.workflow_call.branches: unknown key`)
+	// violations of upstream content only warn
+	assert.Contains(t, out.String(), "master/build/.github/workflows/ci.yml: warning: master/keycloak/.github/workflows/ci.yml:8:9: jobs.build: must have uses or runs-on \n")
+	assert.False(t, fileExists(fsys, "master/build/.github/workflows/ci.yml"))
+}
+
+func TestUpstreamSchemaViolationsAreWarnings(t *testing.T) {
+	fsys := newMemFS()
+	assert.NoError(t, fsys.WriteFile("master/keycloak/.github/workflows/ci.yml", []byte("name: CI\njobs: {}\n"), 0644))
+	assert.NoError(t, fsys.WriteFile("master/patches/.github/workflows/ci.yml", []byte("env: {A: 1}\n"), 0644))
+
+	var out bytes.Buffer
+	assert.NoError(t, newMerger(fsys, defaultConfig(), &out).mergeVersions("master"))
+	assert.Contains(t, out.String(), "master/build/.github/workflows/ci.yml: warning: master/keycloak/.github/workflows/ci.yml:1:1: <root>: missing required key on \n")
+	assert.Contains(t, out.String(), "master/build/.github/workflows/ci.yml: warning: master/keycloak/.github/workflows/ci.yml:2:7: jobs: must have at least 1 key \n")
+	assert.True(t, fileExists(fsys, "master/build/.github/workflows/ci.yml"))
+}
+
+func TestSchemaViolationsOfTransformsFail(t *testing.T) {
+	fsys := newMemFS()
+	assert.NoError(t, fsys.WriteFile("master/keycloak/.github/workflows/ci.yml", []byte(`name: CI
+on: push
+jobs:
+    build:
+        runs-on: ubuntu-latest
+        steps:
+            - run: ./mvnw install
+`), 0644))
+	assert.NoError(t, fsys.WriteFile("master/patches/.github/workflows/ci.yml", []byte("env: {A: 1}\n"), 0644))
+	cfg := defaultConfig()
+	assert.NoError(t, yaml.Unmarshal([]byte("[{runs-on: {group: linux, size: large}}]\n"), &cfg.Transforms.Runners))
+
+	err := newMerger(fsys, cfg, &bytes.Buffer{}).mergeVersions("master")
+	assert.EqualError(t, err, `Schema violations in "master/build/.github/workflows/ci.yml":
+  jobs.build.runs-on.size: unknown key (set by a transform)`)
+	assert.False(t, fileExists(fsys, "master/build/.github/workflows/ci.yml"))
+}
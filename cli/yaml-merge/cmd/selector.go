@@ -0,0 +1,181 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Selectors that do not name a single version directory.
+const (
+	SelectorAll    = "all"
+	SelectorLatest = "latest"
+)
+
+var versionSelectorPattern = regexp.MustCompile(`^v(\d+)(?:[./](\d+))?$`)
+
+// resolveSelectors resolves every selector and returns the union of the
+// selected channels in the order of sortChannels.
+func resolveSelectors(fsys fs.ReadDirFS, layout Layout, selectors []string) ([]Channel, error) {
+	available, err := layout.Discover(fsys)
+	if err != nil {
+		return nil, err
+	}
+	sortChannels(available)
+
+	var selected []Channel
+	seen := map[string]bool{}
+	for _, selector := range selectors {
+		channels, err := resolveSelector(available, selector)
+		if err != nil {
+			return nil, err
+		}
+		for _, channel := range channels {
+			if !seen[channel.Name] {
+				seen[channel.Name] = true
+				selected = append(selected, channel)
+			}
+		}
+	}
+	sortChannels(selected)
+	return selected, nil
+}
+
+// resolveSelector picks the channels a selector stands for among the
+// available ones:
+//
+//	all      every channel
+//	latest   the highest major version, resolved like vN
+//	vN       the newest minor tree vN/M, or vN itself when it has no minor trees
+//	vN.M     the minor tree vN/M (vN/M is accepted as well)
+//	<root>   the channel rooted at that directory, e.g. releases/v20/latest
+//	         for the major tree of v20 even when it has minor trees
+//
+// Any other selector has to name a channel exactly, e.g. master or nightly.
+func resolveSelector(available []Channel, selector string) ([]Channel, error) {
+	switch selector {
+	case SelectorAll:
+		if len(available) == 0 {
+			return nil, errors.New("No versions found")
+		}
+		return available, nil
+	case SelectorLatest:
+		latest := ""
+		for _, channel := range available {
+			if channel.Major != "" && (latest == "" || versionLess(latest, channel.Major)) {
+				latest = channel.Major
+			}
+		}
+		if latest == "" {
+			return nil, unknownSelectorError(available, selector)
+		}
+		selector = "v" + latest
+	}
+
+	for _, channel := range available {
+		if channel.Root == path.Clean(selector) {
+			return []Channel{channel}, nil
+		}
+	}
+	if match := versionSelectorPattern.FindStringSubmatch(selector); match != nil {
+		major, minor := trimZeros(match[1]), match[2]
+		var newest *Channel
+		for i, channel := range available {
+			if trimZeros(channel.Major) != major {
+				continue
+			}
+			if minor != "" {
+				if channel.Minor != "" && trimZeros(channel.Minor) == trimZeros(minor) {
+					return []Channel{channel}, nil
+				}
+				continue
+			}
+			if newest == nil || channelLess(*newest, channel) {
+				newest = &available[i]
+			}
+		}
+		if newest != nil {
+			return []Channel{*newest}, nil
+		}
+		return nil, unknownSelectorError(available, selector)
+	}
+
+	for _, channel := range available {
+		if channel.Name == selector {
+			return []Channel{channel}, nil
+		}
+	}
+	return nil, unknownSelectorError(available, selector)
+}
+
+// unknownSelectorError reports a selector that matches nothing together
+// with the selectors that would.
+func unknownSelectorError(available []Channel, selector string) error {
+	names := make([]string, 0, len(available))
+	for _, channel := range available {
+		names = append(names, channelSelector(channel))
+	}
+	if len(names) == 0 {
+		return errors.New(fmt.Sprintf("Version not found: %s (no versions available)", selector))
+	}
+	return errors.New(fmt.Sprintf("Version not found: %s (available: %s)", selector, strings.Join(names, ", ")))
+}
+
+// channelSelector returns the selector that picks exactly this channel.
+func channelSelector(channel Channel) string {
+	if channel.Major != "" && channel.Minor != "" {
+		return fmt.Sprintf("v%s.%s", channel.Major, channel.Minor)
+	}
+	return channel.Name
+}
+
+// sortChannels orders channels with the unversioned ones (master, nightly)
+// first by name, followed by the versioned ones from oldest to newest.
+func sortChannels(channels []Channel) {
+	sort.SliceStable(channels, func(i, j int) bool {
+		return channelLess(channels[i], channels[j])
+	})
+}
+
+// channelLess orders two channels as described in sortChannels. A major
+// tree sorts before its minor trees.
+func channelLess(a, b Channel) bool {
+	if (a.Major == "") != (b.Major == "") {
+		return a.Major == ""
+	}
+	if a.Major == "" {
+		return a.Name < b.Name
+	}
+	if trimZeros(a.Major) != trimZeros(b.Major) {
+		return versionLess(a.Major, b.Major)
+	}
+	if (a.Minor == "") != (b.Minor == "") {
+		return a.Minor == ""
+	}
+	if trimZeros(a.Minor) != trimZeros(b.Minor) {
+		return versionLess(a.Minor, b.Minor)
+	}
+	return a.Name < b.Name
+}
+
+// versionLess compares two decimal version numbers numerically.
+func versionLess(a, b string) bool {
+	a, b = trimZeros(a), trimZeros(b)
+	if len(a) != len(b) {
+		return len(a) < len(b)
+	}
+	return a < b
+}
+
+// trimZeros drops leading zeros so that "07" and "7" compare equal.
+func trimZeros(n string) string {
+	if v, err := strconv.ParseUint(n, 10, 64); err == nil {
+		return strconv.FormatUint(v, 10)
+	}
+	return n
+}
@@ -0,0 +1,81 @@
+package cmd
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// newReleaseTree creates the channel roots of a workspace shaped like this
+// repository.
+func newReleaseTree(t *testing.T, roots ...string) *memFS {
+	fsys := newMemFS()
+	for _, root := range roots {
+		assert.NoError(t, fsys.MkdirAll(root, 0755))
+	}
+	return fsys
+}
+
+func channelNames(channels []Channel) []string {
+	var names []string
+	for _, channel := range channels {
+		names = append(names, channel.Name)
+	}
+	return names
+}
+
+func TestResolveSelectors(t *testing.T) {
+	fsys := newReleaseTree(t,
+		"master",
+		"releases/nightly",
+		"releases/v9/latest",
+		"releases/v20/latest",
+		"releases/v20/0/latest",
+		"releases/v20/2/latest",
+		"releases/v21/latest",
+	)
+
+	for selector, expected := range map[string][]string{
+		"master":  {"master"},
+		"nightly": {"nightly"},
+		"v9":      {"v9"},
+		"v20":     {"v20/2"},
+		"v20.0":   {"v20/0"},
+		"v20/2":   {"v20/2"},
+		"v21":     {"v21"},
+		"latest":  {"v21"},
+		"all":     {"master", "nightly", "v9", "v20", "v20/0", "v20/2", "v21"},
+	} {
+		channels, err := resolveSelectors(fsys, Layout{}, []string{selector})
+		assert.NoError(t, err, selector)
+		assert.Equal(t, expected, channelNames(channels), selector)
+	}
+
+	channels, err := resolveSelectors(fsys, Layout{}, []string{"v21", "master", "v21"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"master", "v21"}, channelNames(channels))
+
+	minor, err := resolveSelectors(fsys, Layout{}, []string{"v20.2"})
+	assert.NoError(t, err)
+	assert.Equal(t, "releases/v20/2/latest/patches", minor[0].Patches)
+
+	// the major tree of a version with minor trees is selected by its root
+	major, err := resolveSelectors(fsys, Layout{}, []string{"releases/v20/latest"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"v20"}, channelNames(major))
+	master, err := resolveSelectors(fsys, Layout{}, []string{"./master/"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"master"}, channelNames(master))
+}
+
+func TestResolveSelectorListsAvailableVersions(t *testing.T) {
+	fsys := newReleaseTree(t, "master", "releases/v20/latest", "releases/v20/0/latest")
+
+	for _, selector := range []string{"v19", "v20.1", "stable", "nightly"} {
+		_, err := resolveSelectors(fsys, Layout{}, []string{selector})
+		assert.EqualError(t, err, "Version not found: "+selector+" (available: master, v20, v20.0)")
+	}
+
+	_, err := resolveSelectors(newMemFS(), Layout{}, []string{"latest"})
+	assert.EqualError(t, err, "Version not found: latest (no versions available)")
+}
@@ -0,0 +1,351 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Transforms configures the rewrites applied to every merged document
+// before it is written. A transform is enabled by its section being present.
+type Transforms struct {
+	Triggers    []TriggerSpec         `yaml:"triggers"`
+	Schedules   []ScheduleSpec        `yaml:"schedules"`
+	Jobs        []JobsSpec            `yaml:"jobs"`
+	Matrix      []MatrixSpec          `yaml:"matrix"`
+	Runners     []RunnerSpec          `yaml:"runners"`
+	Relocate    *RelocateTransform    `yaml:"relocate"`
+	Namespace   *NamespaceTransform   `yaml:"namespace"`
+	Actions     *ActionPolicy         `yaml:"actions"`
+	Permissions *PermissionsTransform `yaml:"permissions"`
+	Pin         *PinTransform         `yaml:"pin"`
+}
+
+// validate checks the settings of every enabled transform.
+func (t Transforms) validate() error {
+	for i, spec := range t.Triggers {
+		if err := spec.validate(); err != nil {
+			return fmt.Errorf("transforms.triggers[%d]: %w", i, err)
+		}
+	}
+	for i, spec := range t.Schedules {
+		if err := spec.validate(); err != nil {
+			return fmt.Errorf("transforms.schedules[%d]: %w", i, err)
+		}
+	}
+	for i, spec := range t.Jobs {
+		if err := spec.validate(); err != nil {
+			return fmt.Errorf("transforms.jobs[%d]: %w", i, err)
+		}
+	}
+	for i, spec := range t.Matrix {
+		if err := spec.validate(); err != nil {
+			return fmt.Errorf("transforms.matrix[%d]: %w", i, err)
+		}
+	}
+	for i, spec := range t.Runners {
+		if err := spec.validate(); err != nil {
+			return fmt.Errorf("transforms.runners[%d]: %w", i, err)
+		}
+	}
+	if t.Namespace != nil {
+		if err := t.Namespace.validate(); err != nil {
+			return fmt.Errorf("transforms.namespace: %w", err)
+		}
+	}
+	if t.Actions != nil {
+		if err := t.Actions.validate(); err != nil {
+			return fmt.Errorf("transforms.actions: %w", err)
+		}
+	}
+	if t.Permissions != nil {
+		if err := t.Permissions.validate(); err != nil {
+			return fmt.Errorf("transforms.permissions: %w", err)
+		}
+	}
+	if t.Pin != nil {
+		if err := t.Pin.validate(); err != nil {
+			return fmt.Errorf("transforms.pin: %w", err)
+		}
+	}
+	return nil
+}
+
+// WorkflowSelector selects the workflow files a transform spec applies to.
+// Specs embed it, so its keys sit next to their own.
+type WorkflowSelector struct {
+	// Match selects channels by name like a channel layout does ("master",
+	// "v{major}"); an empty Match selects every channel.
+	Match string `yaml:"match"`
+	// Workflows restricts the spec to workflow file names matching one of
+	// these globs; it applies to every workflow when empty.
+	Workflows []string `yaml:"workflows"`
+}
+
+// validate checks the globs of the selector.
+func (s WorkflowSelector) validate() error {
+	if err := validateGlobs(s.Workflows); err != nil {
+		return fmt.Errorf("workflows: %w", err)
+	}
+	return nil
+}
+
+// matches reports whether a workflow file of channel is selected.
+func (s WorkflowSelector) matches(channel Channel, workflow string) bool {
+	if s.Match != "" {
+		if _, ok := matchPlaceholders(s.Match, channel.Name); !ok {
+			return false
+		}
+	}
+	return len(s.Workflows) == 0 || matchingGlob(s.Workflows, path.Base(workflow)) != ""
+}
+
+// validateGlobs checks that every glob is a valid path.Match pattern.
+func validateGlobs(globs []string) error {
+	for _, glob := range globs {
+		if _, err := path.Match(glob, ""); err != nil {
+			return fmt.Errorf("%q: %w", glob, err)
+		}
+	}
+	return nil
+}
+
+// transformContext describes the file a transform rewrites.
+type transformContext struct {
+	channel Channel
+	// path is the output path of the file.
+	path string
+	// out receives what the transform has to report.
+	out io.Writer
+}
+
+// isWorkflow reports whether the file is a workflow.
+func (c transformContext) isWorkflow() bool {
+	return path.Base(path.Dir(c.path)) == "workflows" && path.Base(path.Dir(path.Dir(c.path))) == ".github"
+}
+
+// isAction reports whether the file is the metadata file of an action.
+func (c transformContext) isAction() bool {
+	base := path.Base(c.path)
+	return base == "action.yml" || base == "action.yaml"
+}
+
+// report prints a line about the file to the transform's output.
+func (c transformContext) report(format string, args ...interface{}) {
+	fmt.Fprintf(c.out, "%s: %s \n", c.path, fmt.Sprintf(format, args...))
+}
+
+// transform rewrites the merged document of one file in place.
+type transform func(ctx transformContext, doc *yaml.Node) error
+
+// transforms returns the transforms enabled in the configuration, in the
+// order they run.
+func (c *Config) transforms() []transform {
+	var transforms []transform
+	if len(c.Transforms.Triggers) > 0 {
+		transforms = append(transforms, triggerTransform(c.Transforms.Triggers).apply)
+	}
+	if len(c.Transforms.Schedules) > 0 {
+		transforms = append(transforms, scheduleTransform(c.Transforms.Schedules).apply)
+	}
+	if len(c.Transforms.Jobs) > 0 {
+		transforms = append(transforms, jobsTransform(c.Transforms.Jobs).apply)
+	}
+	if len(c.Transforms.Matrix) > 0 {
+		transforms = append(transforms, matrixTransform(c.Transforms.Matrix).apply)
+	}
+	if len(c.Transforms.Runners) > 0 {
+		transforms = append(transforms, runnerTransform(c.Transforms.Runners).apply)
+	}
+	if c.Transforms.Relocate != nil {
+		transforms = append(transforms, c.Transforms.Relocate.apply)
+	}
+	if c.Transforms.Namespace != nil {
+		transforms = append(transforms, c.Transforms.Namespace.apply)
+	}
+	if c.Transforms.Actions != nil {
+		transforms = append(transforms, c.Transforms.Actions.apply)
+	}
+	if c.Transforms.Permissions != nil {
+		transforms = append(transforms, c.Transforms.Permissions.apply)
+	}
+	if c.Transforms.Pin != nil {
+		transforms = append(transforms, c.Transforms.Pin.apply)
+	}
+	return transforms
+}
+
+// applyTransforms runs every transform of the configuration on doc.
+func (c *Config) applyTransforms(ctx transformContext, doc *yaml.Node) error {
+	for _, t := range c.transforms() {
+		if err := t(ctx, doc); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// documentRoot returns the top-level node of a parsed document, or nil when
+// the document is not a mapping.
+func documentRoot(doc *yaml.Node) *yaml.Node {
+	if doc.Kind == yaml.DocumentNode && len(doc.Content) > 0 {
+		doc = doc.Content[0]
+	}
+	if doc.Kind != yaml.MappingNode {
+		return nil
+	}
+	return doc
+}
+
+// mappingValue returns the value of key in a mapping node, or nil when node
+// is not a mapping or has no such key.
+func mappingValue(node *yaml.Node, key string) *yaml.Node {
+	if node == nil || node.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// setMappingValue sets key to value in a mapping node, appending the key
+// when it is missing.
+func setMappingValue(node *yaml.Node, key string, value *yaml.Node) {
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			node.Content[i+1] = value
+			return
+		}
+	}
+	node.Content = append(node.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: key}, value)
+}
+
+// insertMappingValue sets key to value in a mapping node, inserting the key
+// before the key before, or appending it when before is missing.
+func insertMappingValue(node *yaml.Node, before, key string, value *yaml.Node) {
+	if mappingValue(node, key) != nil {
+		setMappingValue(node, key, value)
+		return
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == before {
+			entry := []*yaml.Node{stringNode(key), value}
+			node.Content = append(node.Content[:i], append(entry, node.Content[i:]...)...)
+			return
+		}
+	}
+	setMappingValue(node, key, value)
+}
+
+// sortedKeys returns the keys of m in order.
+func sortedKeys[T any](m map[string]T) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// stringNode returns a plain string scalar.
+func stringNode(value string) *yaml.Node {
+	return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: value}
+}
+
+// removeMappingKey deletes key from a mapping node and reports whether it
+// was present.
+func removeMappingKey(node *yaml.Node, key string) bool {
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			node.Content = append(node.Content[:i], node.Content[i+2:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// nullNode returns an empty value, as in "workflow_dispatch:".
+func nullNode() *yaml.Node {
+	return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!null"}
+}
+
+// mappingEntries calls fn for every key and value of a mapping node.
+func mappingEntries(node *yaml.Node, fn func(key string, value *yaml.Node)) {
+	if node == nil || node.Kind != yaml.MappingNode {
+		return
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		fn(node.Content[i].Value, node.Content[i+1])
+	}
+}
+
+// forEachJob calls fn for every job of a workflow.
+func forEachJob(root *yaml.Node, fn func(id string, job *yaml.Node)) {
+	mappingEntries(mappingValue(root, "jobs"), func(id string, job *yaml.Node) {
+		if job.Kind == yaml.MappingNode {
+			fn(id, job)
+		}
+	})
+}
+
+// forEachStepList calls fn for the step sequence of every job of a workflow
+// and of a composite action, with the id of the job or "" for the action.
+func forEachStepList(root *yaml.Node, fn func(job string, steps *yaml.Node)) {
+	visit := func(job string, steps *yaml.Node) {
+		if steps != nil && steps.Kind == yaml.SequenceNode {
+			fn(job, steps)
+		}
+	}
+	forEachJob(root, func(id string, job *yaml.Node) {
+		visit(id, mappingValue(job, "steps"))
+	})
+	visit("", mappingValue(mappingValue(root, "runs"), "steps"))
+}
+
+// forEachStep calls fn for every step of a workflow's jobs or of a
+// composite action.
+func forEachStep(root *yaml.Node, fn func(step *yaml.Node)) {
+	forEachStepList(root, func(_ string, steps *yaml.Node) {
+		for _, step := range steps.Content {
+			if step.Kind == yaml.MappingNode {
+				fn(step)
+			}
+		}
+	})
+}
+
+// stepAction returns the action a step uses without its ref, e.g.
+// actions/upload-artifact for actions/upload-artifact@v3, or "" for steps
+// that run a command.
+func stepAction(step *yaml.Node) string {
+	uses := mappingValue(step, "uses")
+	if uses == nil || uses.Kind != yaml.ScalarNode {
+		return ""
+	}
+	action, _, _ := strings.Cut(uses.Value, "@")
+	return action
+}
+
+// rewriteScalars replaces the value of every scalar below node with the
+// result of rewrite. Mapping keys are left alone.
+func rewriteScalars(node *yaml.Node, rewrite func(string) string) {
+	switch node.Kind {
+	case yaml.ScalarNode:
+		node.Value = rewrite(node.Value)
+	case yaml.MappingNode:
+		for i := 1; i < len(node.Content); i += 2 {
+			rewriteScalars(node.Content[i], rewrite)
+		}
+	default:
+		for _, child := range node.Content {
+			rewriteScalars(child, rewrite)
+		}
+	}
+}
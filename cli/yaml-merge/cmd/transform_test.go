@@ -0,0 +1,25 @@
+package cmd
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v3"
+)
+
+func TestWorkflowSelector(t *testing.T) {
+	var spec JobsSpec
+	assert.NoError(t, yaml.Unmarshal([]byte("match: v{major}\nworkflows: [ci.yml, 'operator-*.yml']\ninclude: [build]\n"), &spec))
+	assert.Equal(t, WorkflowSelector{Match: "v{major}", Workflows: []string{"ci.yml", "operator-*.yml"}}, spec.WorkflowSelector)
+
+	v21 := Channel{Name: "v21"}
+	assert.True(t, spec.matches(v21, "releases/v21/latest/build/.github/workflows/ci.yml"))
+	assert.True(t, spec.matches(v21, "releases/v21/latest/build/.github/workflows/operator-ci.yml"))
+	assert.False(t, spec.matches(v21, "releases/v21/latest/build/.github/workflows/release.yml"))
+	assert.False(t, spec.matches(Channel{Name: "master"}, "master/build/.github/workflows/ci.yml"))
+	assert.True(t, WorkflowSelector{}.matches(Channel{Name: "master"}, "master/build/.github/workflows/ci.yml"))
+
+	assert.NoError(t, spec.validate())
+	assert.EqualError(t, WorkflowSelector{Workflows: []string{"["}}.validate(), `workflows: "[": syntax error in pattern`)
+	assert.EqualError(t, JobsSpec{Exclude: []string{"["}}.validate(), `exclude: "[": syntax error in pattern`)
+}
@@ -0,0 +1,224 @@
+package cmd
+
+import (
+	"fmt"
+	"path"
+	"sort"
+
+	"gopkg.in/yaml.v3"
+)
+
+// TriggerSpec declares the triggers of the workflows of the channels it
+// matches. The generated on: section is merged over the upstream one.
+type TriggerSpec struct {
+	WorkflowSelector `yaml:",inline"`
+	// Paths are added to the push paths filter, which always holds the
+	// channel root and the version's published workflows and actions.
+	Paths []string `yaml:"paths"`
+	// BranchesIgnore lists the branches pushes to which are ignored.
+	BranchesIgnore []string `yaml:"branches-ignore"`
+	// WorkflowDispatch allows starting the workflows by hand.
+	WorkflowDispatch bool `yaml:"workflow-dispatch"`
+	// WorkflowCall makes the workflows reusable.
+	WorkflowCall *WorkflowCallSpec `yaml:"workflow-call"`
+}
+
+// WorkflowCallSpec declares the inputs and secrets of a reusable workflow.
+type WorkflowCallSpec struct {
+	Inputs  map[string]WorkflowCallInput  `yaml:"inputs"`
+	Secrets map[string]WorkflowCallSecret `yaml:"secrets"`
+}
+
+// WorkflowCallInput is one input of a reusable workflow.
+type WorkflowCallInput struct {
+	Description string `yaml:"description,omitempty"`
+	Required    bool   `yaml:"required"`
+	Type        string `yaml:"type"`
+	Default     string `yaml:"default,omitempty"`
+}
+
+// WorkflowCallSecret is one secret of a reusable workflow.
+type WorkflowCallSecret struct {
+	Description string `yaml:"description,omitempty"`
+	Required    bool   `yaml:"required"`
+}
+
+// validate checks the patterns and input types of a trigger spec.
+func (s TriggerSpec) validate() error {
+	if err := s.WorkflowSelector.validate(); err != nil {
+		return err
+	}
+	if s.WorkflowCall == nil {
+		return nil
+	}
+	for name, input := range s.WorkflowCall.Inputs {
+		switch input.Type {
+		case "boolean", "number", "string":
+		default:
+			return fmt.Errorf("workflow-call input %s: type must be boolean, number or string", name)
+		}
+	}
+	return nil
+}
+
+// triggerTransform generates the triggers of workflows from the first
+// spec matching each of them.
+type triggerTransform []TriggerSpec
+
+// apply merges the generated triggers over the on: section of a workflow.
+func (specs triggerTransform) apply(ctx transformContext, doc *yaml.Node) error {
+	root := documentRoot(doc)
+	if root == nil || !ctx.isWorkflow() {
+		return nil
+	}
+	for _, spec := range specs {
+		if spec.matches(ctx.channel, ctx.path) {
+			return spec.apply(ctx, root)
+		}
+	}
+	return nil
+}
+
+// versionPaths returns the paths whose changes trigger the workflows of
+// channel: everything below its root, its published workflows and its
+// vendored actions.
+func versionPaths(channel Channel) []string {
+	version := channelSelector(channel)
+	return []string{
+		channel.Root + "/**",
+		path.Join(PublishedWorkflowsDir, version+"-*.yml"),
+		path.Join(PublishedActionsDir, version, "**"),
+	}
+}
+
+// apply merges the triggers declared by the spec over the on: section of
+// root. Generated filters replace the upstream ones GitHub does not allow
+// next to them.
+func (s TriggerSpec) apply(ctx transformContext, root *yaml.Node) error {
+	on := eventMapping(root)
+
+	push := eventSettings(on, "push")
+	setUnion(push, "paths", append(versionPaths(ctx.channel), s.Paths...))
+	if removeMappingKey(push, "paths-ignore") {
+		ctx.report("push paths-ignore replaced by the generated paths filter")
+	}
+	if len(s.BranchesIgnore) > 0 {
+		setUnion(push, "branches-ignore", s.BranchesIgnore)
+		if removeMappingKey(push, "branches") {
+			ctx.report("push branches replaced by the generated branches-ignore filter")
+		}
+	}
+
+	if s.WorkflowDispatch && mappingValue(on, "workflow_dispatch") == nil {
+		setMappingValue(on, "workflow_dispatch", nullNode())
+	}
+
+	if s.WorkflowCall != nil {
+		call := eventSettings(on, "workflow_call")
+		if err := setSortedEntries(call, "inputs", s.WorkflowCall.Inputs); err != nil {
+			return err
+		}
+		if err := setSortedEntries(call, "secrets", s.WorkflowCall.Secrets); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// eventMapping returns the on: section of a workflow as a mapping of
+// events, converting the single event and event list forms.
+func eventMapping(root *yaml.Node) *yaml.Node {
+	on := mappingValue(root, "on")
+	switch {
+	case on == nil:
+		on = &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+		setMappingValue(root, "on", on)
+	case on.Kind == yaml.ScalarNode:
+		*on = yaml.Node{Kind: yaml.MappingNode, Tag: "!!map", Content: []*yaml.Node{stringNode(on.Value), nullNode()}}
+	case on.Kind == yaml.SequenceNode:
+		events := on.Content
+		*on = yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+		for _, event := range events {
+			on.Content = append(on.Content, stringNode(event.Value), nullNode())
+		}
+	}
+	return on
+}
+
+// eventNames returns the events of an on: section in any of its forms.
+func eventNames(on *yaml.Node) []string {
+	if on == nil {
+		return nil
+	}
+	switch on.Kind {
+	case yaml.ScalarNode:
+		return []string{on.Value}
+	case yaml.SequenceNode:
+		var events []string
+		for _, event := range on.Content {
+			events = append(events, event.Value)
+		}
+		return events
+	}
+	var events []string
+	mappingEntries(on, func(event string, _ *yaml.Node) {
+		events = append(events, event)
+	})
+	return events
+}
+
+// eventSettings returns the settings of event as a mapping, adding the
+// event or replacing an empty value.
+func eventSettings(on *yaml.Node, event string) *yaml.Node {
+	settings := mappingValue(on, event)
+	if settings == nil || settings.Kind != yaml.MappingNode {
+		settings = &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+		setMappingValue(on, event, settings)
+	}
+	return settings
+}
+
+// setUnion adds the values missing from the sequence under key.
+func setUnion(node *yaml.Node, key string, values []string) {
+	seq := mappingValue(node, key)
+	if seq == nil || seq.Kind != yaml.SequenceNode {
+		seq = &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq"}
+		setMappingValue(node, key, seq)
+	}
+	present := map[string]bool{}
+	for _, item := range seq.Content {
+		present[item.Value] = true
+	}
+	for _, value := range values {
+		if !present[value] {
+			present[value] = true
+			seq.Content = append(seq.Content, stringNode(value))
+		}
+	}
+}
+
+// setSortedEntries encodes every entry of values in key order and sets it
+// under key, replacing an entry of the same name.
+func setSortedEntries[T any](node *yaml.Node, key string, values map[string]T) error {
+	if len(values) == 0 {
+		return nil
+	}
+	entries := mappingValue(node, key)
+	if entries == nil || entries.Kind != yaml.MappingNode {
+		entries = &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+		setMappingValue(node, key, entries)
+	}
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		var value yaml.Node
+		if err := value.Encode(values[name]); err != nil {
+			return err
+		}
+		setMappingValue(entries, name, &value)
+	}
+	return nil
+}
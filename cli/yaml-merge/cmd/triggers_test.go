@@ -0,0 +1,102 @@
+package cmd
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTriggerTransform(t *testing.T) {
+	fsys := newMemFS()
+	for _, root := range []string{"master", "releases/v21/latest"} {
+		assert.NoError(t, fsys.WriteFile(root+"/keycloak/.github/workflows/ci.yml", []byte(`name: CI
+on:
+    push:
+        branches: [main]
+        paths-ignore: ['docs/**']
+    pull_request:
+jobs: {}
+`), 0644))
+		assert.NoError(t, fsys.WriteFile(root+"/keycloak/.github/workflows/docs.yml", []byte("name: Docs\non: [push, pull_request]\n"), 0644))
+		assert.NoError(t, fsys.WriteFile(root+"/patches/.github/workflows/ci.yml", []byte("env: {A: 1}\n"), 0644))
+		assert.NoError(t, fsys.WriteFile(root+"/patches/.github/workflows/docs.yml", []byte("env: {A: 1}\n"), 0644))
+	}
+	assert.NoError(t, fsys.WriteFile(DefaultConfigFile, []byte(`transforms:
+    triggers:
+        - match: master
+          workflows: [ci.yml]
+          paths: [cli/**]
+          branches-ignore: [master, dependabot/**]
+          workflow-dispatch: true
+          workflow-call:
+              inputs:
+                  config-path: {required: true, type: string}
+              secrets:
+                  envPAT: {required: true}
+        - {}
+`), 0644))
+	cfg, err := loadConfig(fsys, DefaultConfigFile, true)
+	assert.NoError(t, err)
+	var out bytes.Buffer
+	assert.NoError(t, newMerger(fsys, cfg, &out).mergeVersions("all"))
+	assert.Contains(t, out.String(), "master/build/.github/workflows/ci.yml: push paths-ignore replaced by the generated paths filter")
+	assert.Contains(t, out.String(), "master/build/.github/workflows/ci.yml: push branches replaced by the generated branches-ignore filter")
+
+	data, err := fsys.ReadFile("master/build/.github/workflows/ci.yml")
+	assert.NoError(t, err)
+	assert.Equal(t, `name: CI
+on:
+    push:
+        paths:
+            - master/**
+            - .github/workflows/master-*.yml
+            - .github/actions/master/**
+            - cli/**
+        branches-ignore:
+            - master
+            - dependabot/**
+    pull_request:
+    workflow_dispatch:
+    workflow_call:
+        inputs:
+            config-path:
+                required: true
+                type: string
+        secrets:
+            envPAT:
+                required: true
+jobs: {}
+env: {A: 1}
+`, generatedBody(t, data))
+
+	// the catch-all spec only adds the version's paths
+	data, err = fsys.ReadFile("master/build/.github/workflows/docs.yml")
+	assert.NoError(t, err)
+	assert.Equal(t, `name: Docs
+on:
+    push:
+        paths:
+            - master/**
+            - .github/workflows/master-*.yml
+            - .github/actions/master/**
+    pull_request:
+env: {A: 1}
+`, generatedBody(t, data))
+
+	data, err = fsys.ReadFile("releases/v21/latest/build/.github/workflows/ci.yml")
+	assert.NoError(t, err)
+	assert.Contains(t, generatedBody(t, data), `
+        branches: [main]
+        paths:
+            - releases/v21/latest/**
+            - .github/workflows/v21-*.yml
+            - .github/actions/v21/**
+`)
+}
+
+func TestTriggerSpecValidation(t *testing.T) {
+	spec := TriggerSpec{WorkflowCall: &WorkflowCallSpec{Inputs: map[string]WorkflowCallInput{"x": {Type: "object"}}}}
+	assert.EqualError(t, spec.validate(), "workflow-call input x: type must be boolean, number or string")
+	assert.Error(t, TriggerSpec{WorkflowSelector: WorkflowSelector{Workflows: []string{"["}}}.validate())
+}
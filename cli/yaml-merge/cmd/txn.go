@@ -0,0 +1,226 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"sync"
+)
+
+// fileWriter is the part of WritableFS used to write generated files, so
+// that they can go either straight to a filesystem or through a writeTxn.
+type fileWriter interface {
+	WriteFile(name string, data []byte, perm fs.FileMode) error
+}
+
+// tempName returns the name of the temporary file a write to name goes
+// through. It lives in the same directory so that the final rename does not
+// cross filesystems.
+func tempName(name string) string {
+	return path.Join(path.Dir(name), "."+path.Base(name)+".yaml-merge-tmp")
+}
+
+// writeFileAtomic writes data to a temporary file next to name and renames
+// it into place, so that readers see either the old or the new content.
+func writeFileAtomic(fsys WritableFS, name string, data []byte, perm fs.FileMode) error {
+	tmp := tempName(name)
+	if err := fsys.WriteFile(tmp, data, perm); err != nil {
+		fsys.Remove(tmp)
+		return err
+	}
+	if err := fsys.Rename(tmp, name); err != nil {
+		fsys.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// writeTxn collects the files generated for one version and writes them as
+// one unit. Nothing touches the filesystem before Commit, and a failed
+// Commit restores every file it had already replaced. It is safe for
+// concurrent use.
+type writeTxn struct {
+	fsys WritableFS
+
+	mu      sync.Mutex
+	staged  map[string]stagedFile
+	aborted bool
+}
+
+type stagedFile struct {
+	data   []byte
+	perm   fs.FileMode
+	remove bool
+}
+
+// replacedFile remembers what a committed file looked like before.
+type replacedFile struct {
+	name    string
+	existed bool
+	data    []byte
+	perm    fs.FileMode
+}
+
+// newWriteTxn starts a transaction on fsys.
+func newWriteTxn(fsys WritableFS) *writeTxn {
+	return &writeTxn{fsys: fsys, staged: map[string]stagedFile{}}
+}
+
+// WriteFile stages data to be written to name on Commit.
+func (t *writeTxn) WriteFile(name string, data []byte, perm fs.FileMode) error {
+	name, err := cleanName("write", name)
+	if err != nil {
+		return err
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.staged[name] = stagedFile{data: append([]byte(nil), data...), perm: perm}
+	return nil
+}
+
+// Remove stages the removal of name on Commit. Removing a file that does
+// not exist is not an error.
+func (t *writeTxn) Remove(name string) error {
+	name, err := cleanName("remove", name)
+	if err != nil {
+		return err
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.staged[name] = stagedFile{remove: true}
+	return nil
+}
+
+// ReadFile returns the content name will have after Commit: the staged data,
+// fs.ErrNotExist when its removal is staged, or the current file otherwise.
+func (t *writeTxn) ReadFile(name string) ([]byte, error) {
+	name, err := cleanName("read", name)
+	if err != nil {
+		return nil, err
+	}
+	t.mu.Lock()
+	staged, ok := t.staged[name]
+	t.mu.Unlock()
+	switch {
+	case !ok:
+		return t.fsys.ReadFile(name)
+	case staged.remove:
+		return nil, &fs.PathError{Op: "read", Path: name, Err: fs.ErrNotExist}
+	}
+	return append([]byte(nil), staged.data...), nil
+}
+
+// Abort marks the transaction as failed; Commit will then discard it.
+func (t *writeTxn) Abort() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.aborted = true
+}
+
+// Aborted reports whether Abort was called.
+func (t *writeTxn) Aborted() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.aborted
+}
+
+// Len returns the number of staged files.
+func (t *writeTxn) Len() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.staged)
+}
+
+// Commit writes every staged file and removes the files staged for removal.
+// All files are first written to temporary files; only when that succeeded
+// are they renamed into place. If a rename or removal fails, the files
+// changed so far get their previous content back (or are removed if they
+// did not exist) and the error is returned.
+func (t *writeTxn) Commit() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.aborted {
+		return errors.New("transaction was aborted")
+	}
+
+	names := make([]string, 0, len(t.staged))
+	for name := range t.staged {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for i, name := range names {
+		staged := t.staged[name]
+		if staged.remove {
+			continue
+		}
+		if err := t.fsys.WriteFile(tempName(name), staged.data, staged.perm); err != nil {
+			t.removeTemps(names[:i+1])
+			return err
+		}
+	}
+
+	replaced := make([]replacedFile, 0, len(names))
+	for i, name := range names {
+		previous := replacedFile{name: name}
+		if info, err := t.fsys.Stat(name); err == nil {
+			data, err := t.fsys.ReadFile(name)
+			if err != nil {
+				t.removeTemps(names[i:])
+				return errors.Join(err, t.restore(replaced))
+			}
+			previous = replacedFile{name: name, existed: true, data: data, perm: info.Mode().Perm()}
+		}
+		if t.staged[name].remove {
+			if previous.existed {
+				if err := t.fsys.Remove(name); err != nil {
+					t.removeTemps(names[i:])
+					return errors.Join(err, t.restore(replaced))
+				}
+				replaced = append(replaced, previous)
+			}
+			continue
+		}
+		if err := t.fsys.Rename(tempName(name), name); err != nil {
+			t.removeTemps(names[i:])
+			return errors.Join(err, t.restore(replaced))
+		}
+		replaced = append(replaced, previous)
+	}
+	t.staged = map[string]stagedFile{}
+	return nil
+}
+
+// Rollback discards the staged files.
+func (t *writeTxn) Rollback() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.staged = map[string]stagedFile{}
+}
+
+// removeTemps deletes the temporary files of names, ignoring missing ones.
+func (t *writeTxn) removeTemps(names []string) {
+	for _, name := range names {
+		t.fsys.Remove(tempName(name))
+	}
+}
+
+// restore puts back the previous content of the replaced files.
+func (t *writeTxn) restore(replaced []replacedFile) error {
+	var errs []error
+	for i := len(replaced) - 1; i >= 0; i-- {
+		r := replaced[i]
+		var err error
+		if r.existed {
+			err = writeFileAtomic(t.fsys, r.name, r.data, r.perm)
+		} else {
+			err = t.fsys.Remove(r.name)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("restoring %s: %w", r.name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,116 @@
+package cmd
+
+import (
+	"errors"
+	"io/fs"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// failingFS fails renames into names containing failOn.
+type failingFS struct {
+	WritableFS
+	failOn string
+}
+
+func (f failingFS) Rename(oldname, newname string) error {
+	if strings.Contains(newname, f.failOn) {
+		return &fs.PathError{Op: "rename", Path: newname, Err: errors.New("disk full")}
+	}
+	return f.WritableFS.Rename(oldname, newname)
+}
+
+func TestWriteTxnCommit(t *testing.T) {
+	fsys := newMemFS()
+	assert.NoError(t, fsys.WriteFile("build/a.yml", []byte("old"), 0644))
+
+	txn := newWriteTxn(fsys)
+	assert.NoError(t, txn.WriteFile("build/a.yml", []byte("new a"), 0644))
+	assert.NoError(t, txn.WriteFile("build/sub/b.yml", []byte("new b"), 0644))
+
+	// nothing is written before the commit
+	data, _ := fsys.ReadFile("build/a.yml")
+	assert.Equal(t, "old", string(data))
+	assert.False(t, fileExists(fsys, "build/sub/b.yml"))
+
+	assert.NoError(t, txn.Commit())
+	data, _ = fsys.ReadFile("build/a.yml")
+	assert.Equal(t, "new a", string(data))
+	data, _ = fsys.ReadFile("build/sub/b.yml")
+	assert.Equal(t, "new b", string(data))
+	assert.False(t, fileExists(fsys, tempName("build/a.yml")))
+}
+
+func TestWriteTxnRollsBackFailedCommit(t *testing.T) {
+	mem := newMemFS()
+	assert.NoError(t, mem.WriteFile("build/a.yml", []byte("old a"), 0644))
+	assert.NoError(t, mem.WriteFile("build/c.yml", []byte("old c"), 0644))
+	fsys := failingFS{WritableFS: mem, failOn: "c.yml"}
+
+	txn := newWriteTxn(fsys)
+	assert.NoError(t, txn.WriteFile("build/a.yml", []byte("new a"), 0644))
+	assert.NoError(t, txn.WriteFile("build/b.yml", []byte("new b"), 0644))
+	assert.NoError(t, txn.WriteFile("build/c.yml", []byte("new c"), 0644))
+
+	err := txn.Commit()
+	assert.ErrorContains(t, err, "disk full")
+
+	data, _ := mem.ReadFile("build/a.yml")
+	assert.Equal(t, "old a", string(data))
+	assert.False(t, fileExists(mem, "build/b.yml"))
+	data, _ = mem.ReadFile("build/c.yml")
+	assert.Equal(t, "old c", string(data))
+	for _, name := range []string{"a.yml", "b.yml", "c.yml"} {
+		assert.False(t, fileExists(mem, tempName("build/"+name)), name)
+	}
+}
+
+func TestWriteTxnAbort(t *testing.T) {
+	fsys := newMemFS()
+	txn := newWriteTxn(fsys)
+	assert.NoError(t, txn.WriteFile("build/a.yml", []byte("a"), 0644))
+	txn.Abort()
+	assert.Error(t, txn.Commit())
+	assert.False(t, fileExists(fsys, "build/a.yml"))
+}
+
+func TestWriteTxnRemove(t *testing.T) {
+	mem := newMemFS()
+	assert.NoError(t, mem.WriteFile("build/a.yml", []byte("old a"), 0644))
+	assert.NoError(t, mem.WriteFile("build/z.yml", []byte("old z"), 0644))
+
+	txn := newWriteTxn(mem)
+	assert.NoError(t, txn.Remove("build/a.yml"))
+	assert.NoError(t, txn.Remove("build/missing.yml"))
+	assert.NoError(t, txn.Commit())
+	assert.False(t, fileExists(mem, "build/a.yml"))
+
+	// a failing commit brings removed files back
+	assert.NoError(t, mem.WriteFile("build/a.yml", []byte("old a"), 0644))
+	txn = newWriteTxn(failingFS{WritableFS: mem, failOn: "z.yml"})
+	assert.NoError(t, txn.Remove("build/a.yml"))
+	assert.NoError(t, txn.WriteFile("build/z.yml", []byte("new z"), 0644))
+	assert.Error(t, txn.Commit())
+	data, err := mem.ReadFile("build/a.yml")
+	assert.NoError(t, err)
+	assert.Equal(t, "old a", string(data))
+}
+
+func TestWriteTxnReadFile(t *testing.T) {
+	fsys := newMemFS()
+	assert.NoError(t, fsys.WriteFile("build/a.yml", []byte("old a"), 0644))
+	assert.NoError(t, fsys.WriteFile("build/b.yml", []byte("old b"), 0644))
+
+	txn := newWriteTxn(fsys)
+	assert.NoError(t, txn.WriteFile("build/a.yml", []byte("new a"), 0644))
+	assert.NoError(t, txn.Remove("build/b.yml"))
+	data, err := txn.ReadFile("build/a.yml")
+	assert.NoError(t, err)
+	assert.Equal(t, "new a", string(data))
+	_, err = txn.ReadFile("build/b.yml")
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+	_, err = txn.ReadFile("build/c.yml")
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+}
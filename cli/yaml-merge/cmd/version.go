@@ -0,0 +1,35 @@
+package cmd
+
+import "runtime/debug"
+
+// Version is the yaml-merge version. Release builds set it with
+// -ldflags "-X yaml-merge/cmd.Version=<version>".
+var Version = ""
+
+// toolVersion returns Version, falling back to the VCS revision the binary
+// was built from.
+func toolVersion() string {
+	if Version != "" {
+		return Version
+	}
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "dev"
+	}
+	revision, modified := "", false
+	for _, setting := range info.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			revision = setting.Value
+		case "vcs.modified":
+			modified = setting.Value == "true"
+		}
+	}
+	if revision == "" {
+		return "dev"
+	}
+	if modified {
+		revision += "-dirty"
+	}
+	return revision
+}